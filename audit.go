@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var auditDirFlag = flag.String("auditDir", "", "Where should nodes keep the audit log of parameter changes? (empty to only keep the latest in memory)")
+var policyFlag = flag.String("policy", "", "YAML file with the policy for changing parameters")
+
+// An attempt to change a parameter. Writes to the packet log use the name
+// "log" and the number of packets as values.
+type AuditEntry struct {
+	Time     int64  `json:"time"`
+	Author   string `json:"author"`
+	Name     string `json:"name"`
+	Old      int    `json:"old"`
+	New      int    `json:"new"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// How many of the latest entries a node keeps in memory, for `ctl audit`.
+const auditEntries = 1000
+
+type AuditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []AuditEntry
+}
+
+// Also append entries to a file as JSON lines, from now on.
+func (a *AuditLog) persist(file string) error {
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file = f
+	return nil
+}
+
+func (a *AuditLog) record(author, name string, old, new int, refused error) {
+	entry := AuditEntry{Time: time.Now().UnixNano(), Author: author, Name: name, Old: old, New: new, Accepted: refused == nil}
+	if refused != nil {
+		entry.Reason = refused.Error()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > auditEntries {
+		a.entries = a.entries[len(a.entries)-auditEntries:]
+	}
+	if a.file != nil {
+		line, _ := json.Marshal(entry)
+		a.file.Write(append(line, '\n'))
+	}
+}
+
+func (a *AuditLog) Entries() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEntry{}, a.entries...)
+}
+
+// Rules for who may change which parameter and when. A rule is a comma
+// separated list of:
+//
+//	rw           anyone may change it (subject to the allow-list)
+//	ro           nobody may change it remotely
+//	coordinator  only the authors in `coordinators` may change it
+//	idle         it may not be changed while the node is running (unpaused)
+type Policy struct {
+	Default      string            `yaml:"default"`
+	Attrs        map[string]string `yaml:"attrs"`
+	Coordinators []string          `yaml:"coordinators"`
+}
+
+// By default, the packet log cannot be overwritten during a test.
+func defaultPolicy() Policy {
+	return Policy{
+		Default:      "rw",
+		Attrs:        map[string]string{"log": "idle"},
+		Coordinators: []string{"coordinator"},
+	}
+}
+
+func loadPolicy(file string) (Policy, error) {
+	policy := defaultPolicy()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return policy, err
+	}
+	err = yaml.Unmarshal(data, &policy)
+	return policy, err
+}
+
+func (p Policy) rule(name string) string {
+	if rule, ok := p.Attrs[name]; ok {
+		return rule
+	}
+	return p.Default
+}
+
+// Check the allow-list and policy, returns why a change is refused.
+func (n *Node) authorize(author, name string) error {
+	if !n.allowed(author) {
+		return fmt.Errorf("Author \"%s\" is not allowed to write \"%s\"", author, name)
+	}
+	for _, rule := range strings.Split(n.policy.rule(name), ",") {
+		switch strings.TrimSpace(rule) {
+		case "", "rw":
+		case "ro":
+			return fmt.Errorf("Field \"%s\" is read-only", name)
+		case "coordinator":
+			ok := false
+			for _, c := range n.policy.Coordinators {
+				ok = ok || c == author
+			}
+			if !ok {
+				return fmt.Errorf("Field \"%s\" can only be written by a coordinator", name)
+			}
+		case "idle":
+			if !n.isPaused() {
+				return fmt.Errorf("Field \"%s\" is locked while a test is running", name)
+			}
+		default:
+			return fmt.Errorf("Field \"%s\" has unknown policy \"%s\"", name, rule)
+		}
+	}
+	return nil
+}
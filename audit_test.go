@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSetLogRequestFormats(t *testing.T) {
+	var r SetLogRequest
+	if err := json.Unmarshal([]byte(`{"author":"coordinator","data":[{"t1":1}]}`), &r); err != nil || r.Author != "coordinator" || len(r.Data) != 1 || r.Data[0].T1 != 1 {
+		t.Errorf("request is %+v, %v", r, err)
+	}
+	r = SetLogRequest{}
+	if err := json.Unmarshal([]byte(` [{"t1":2},{"t1":3}]`), &r); err != nil || r.Author != "" || len(r.Data) != 2 || r.Data[1].T1 != 3 {
+		t.Errorf("old request is %+v, %v", r, err)
+	}
+}
+
+func TestAuditLogLimit(t *testing.T) {
+	a := &AuditLog{}
+	for i := 0; i < auditEntries+10; i++ {
+		a.record("ctl", "rate", i, i+1, nil)
+	}
+	entries := a.Entries()
+	if len(entries) != auditEntries || entries[0].Old != 10 {
+		t.Errorf("%d entries, the first from %d", len(entries), entries[0].Old)
+	}
+}
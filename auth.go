@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v2"
+)
+
+var natsConfigFlag = flag.String("natsConfig", "", "YAML file with NATS credentials and TLS settings, flags take precedence.")
+var natsCredsFlag = flag.String("natsCreds", "", "NATS user credentials file (JWT and NKey seed).")
+var natsNKeyFlag = flag.String("natsNKey", "", "NATS NKey seed file.")
+var natsUserFlag = flag.String("natsUser", "", "NATS user name.")
+var natsPasswordFlag = flag.String("natsPassword", "", "NATS password.")
+var natsTokenFlag = flag.String("natsToken", "", "NATS authentication token.")
+var natsCAFlag = flag.String("natsCA", "", "CA certificate to verify the NATS server with, enables TLS.")
+var natsCertFlag = flag.String("natsCert", "", "Client certificate for NATS TLS.")
+var natsKeyFlag = flag.String("natsKey", "", "Client key for NATS TLS.")
+var natsTLSFlag = flag.Bool("natsTLS", false, "Require TLS to the NATS server, using the system CAs unless -natsCA is set.")
+var allowAuthorsFlag = flag.String("allow", "", "Which authors may change parameters of this node? (comma separated, default anyone)")
+
+type NATSConfig struct {
+	Creds    string   `yaml:"creds"`
+	NKey     string   `yaml:"nkey"`
+	User     string   `yaml:"user"`
+	Password string   `yaml:"password"`
+	Token    string   `yaml:"token"`
+	CA       string   `yaml:"ca"`
+	Cert     string   `yaml:"cert"`
+	Key      string   `yaml:"key"`
+	TLS      bool     `yaml:"tls"`
+	Allow    []string `yaml:"allow"`
+}
+
+// Read the config file, if any, and override it with the flags that are set.
+func loadNATSConfig() (NATSConfig, error) {
+	var conf NATSConfig
+	if len(*natsConfigFlag) != 0 {
+		data, err := ioutil.ReadFile(*natsConfigFlag)
+		if err != nil {
+			return conf, err
+		}
+		if err := yaml.Unmarshal(data, &conf); err != nil {
+			return conf, err
+		}
+	}
+	override := func(dst *string, src string) {
+		if len(src) != 0 {
+			*dst = src
+		}
+	}
+	override(&conf.Creds, *natsCredsFlag)
+	override(&conf.NKey, *natsNKeyFlag)
+	override(&conf.User, *natsUserFlag)
+	override(&conf.Password, *natsPasswordFlag)
+	override(&conf.Token, *natsTokenFlag)
+	override(&conf.CA, *natsCAFlag)
+	override(&conf.Cert, *natsCertFlag)
+	override(&conf.Key, *natsKeyFlag)
+	conf.TLS = conf.TLS || *natsTLSFlag
+	if len(*allowAuthorsFlag) != 0 {
+		conf.Allow = strings.Split(*allowAuthorsFlag, ",")
+	}
+	return conf, nil
+}
+
+func (conf NATSConfig) options() ([]nats.Option, error) {
+	opts := []nats.Option{}
+	if len(conf.Creds) != 0 {
+		opts = append(opts, nats.UserCredentials(conf.Creds))
+	}
+	if len(conf.NKey) != 0 {
+		opt, err := nats.NkeyOptionFromSeed(conf.NKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if len(conf.User) != 0 {
+		opts = append(opts, nats.UserInfo(conf.User, conf.Password))
+	}
+	if len(conf.Token) != 0 {
+		opts = append(opts, nats.Token(conf.Token))
+	}
+	if conf.TLS {
+		opts = append(opts, nats.Secure())
+	}
+	if len(conf.CA) != 0 {
+		opts = append(opts, nats.RootCAs(conf.CA))
+	}
+	if len(conf.Cert) != 0 || len(conf.Key) != 0 {
+		opts = append(opts, nats.ClientCert(conf.Cert, conf.Key))
+	}
+	return opts, nil
+}
+
+// Whether `author` may change parameters, anyone may if no allow-list is set.
+func (n *Node) allowed(author string) bool {
+	if len(n.allow) == 0 {
+		return true
+	}
+	for _, a := range n.allow {
+		if a == author {
+			return true
+		}
+	}
+	return false
+}
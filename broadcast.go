@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var multicastFlag = flag.String("multicast", "", "UDP multicast group (ip:port) an RSU also broadcasts to, and vehicles listen on")
+var broadcastFlag = flag.Bool("broadcast", false, "Run the cases as a broadcast from the sensor (an RSU) and report delivery and awareness per vehicle")
+var awarenessWindow = flag.Duration("awareness", 100*time.Millisecond, "How soon must a vehicle receive a broadcast message to be aware of it?")
+
+// Largest packet that fits in a UDP datagram.
+const maxDatagram = 65507
+
+// A road-side unit that broadcasts packets to every vehicle listening on
+// "<name>.data", and on -multicast. It stands in for both the sensor and
+// the server, so T1 to T3 are the time it sent the packet.
+func newRSU(name string, nc *nats.EncodedConn, ntpClient *NTPClient) *Node {
+	var udp *net.UDPConn
+	if len(*multicastFlag) != 0 {
+		addr, err := net.ResolveUDPAddr("udp", *multicastFlag)
+		if err != nil {
+			panic(err)
+		}
+		if udp, err = net.DialUDP("udp", nil, addr); err != nil {
+			panic(err)
+		}
+	}
+
+	node := NewNode(name, "rsu", nc, ntpClient, func(node *Node) {
+		packets := node.nextPackets()
+		for i := range packets {
+			packets[i].T2, packets[i].E2 = packets[i].T1, packets[i].E1
+			packets[i].T3, packets[i].E3 = packets[i].T1, packets[i].E1
+			node.link.Send(&packets[i], func(p *Packet) {
+				node.nc.Publish(fmt.Sprintf("%s.data", node.name), p)
+				if udp != nil {
+					data, err := json.Marshal(p)
+					if err == nil && len(data) > maxDatagram {
+						err = fmt.Errorf("%d B packet does not fit in a datagram", len(data))
+					}
+					if err == nil {
+						_, err = udp.Write(data)
+					}
+					if err != nil {
+						fmt.Printf("Multicast error: %v\n", err)
+					}
+				}
+			})
+			node.metrics.sent(len(packets[i].Data))
+		}
+	})
+	node.setAttr("DATA_SIZE", 1000)
+	node.setAttr("DATA_SEQ", 0)
+	node.setAttr("DEADLINE", int(deadlineFlag.Milliseconds()))
+	node.link = NewLink(node)
+	node.usePayload()
+	node.useStream()
+	return node
+}
+
+// Receive packets sent to the multicast group until the node dies.
+func (n *Node) listenMulticast(group string, receive func(*Packet)) {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		panic(err)
+	}
+	conn, err := net.ListenMulticastUDP("udp", nil, addr)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, maxDatagram)
+	for n.isAlive() {
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		size, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			continue // timeouts, to check if the node is still alive
+		}
+		var p Packet
+		if err := json.Unmarshal(buf[:size], &p); err != nil {
+			fmt.Printf("Multicast error: %v\n", err)
+			continue
+		}
+		receive(&p)
+	}
+}
+
+// A broadcast message, each sender numbering its own from 0.
+type broadcastMessage struct {
+	source string
+	seq    int64
+}
+
+// How a vehicle received a broadcast: the fraction of the packets the
+// senders sent that it got, and the time between consecutive receptions of
+// any of them.
+func receptionStats(log []Packet, sent map[string]int) (float64, [3]float64, float64) {
+	seen := map[broadcastMessage]bool{}
+	arrivals := []int64{}
+	received := 0
+	for _, p := range log {
+		m := broadcastMessage{p.Source, p.Header.Seq}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		arrivals = append(arrivals, p.T4+p.E4)
+		if m.seq >= 0 && m.seq < int64(sent[m.source]) {
+			received++
+		}
+	}
+	total := 0
+	for _, n := range sent {
+		total += n
+	}
+	pdr := 0.0
+	if total > 0 {
+		pdr = float64(received) / float64(total)
+	}
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i] < arrivals[j] })
+	irts := []float64{}
+	for i := 1; i < len(arrivals); i++ {
+		irts = append(irts, ms(time.Duration(arrivals[i]-arrivals[i-1])))
+	}
+	if len(irts) == 0 {
+		return pdr, [3]float64{}, 0
+	}
+	p := latencyPercentiles(irts) // sorts them
+	return pdr, p, irts[len(irts)-1]
+}
+
+// How many vehicles received a broadcast message within the window.
+type messageAwareness struct {
+	broadcastMessage
+	sent      int64 // NTP corrected [ns]
+	receivers int
+	latest    time.Duration // of the vehicles in time
+}
+
+// Awareness of every message the senders sent over the vehicles, in order
+// of the senders.
+func broadcastAwareness(vehicles []string, logs map[string][]Packet, senders []string, sent map[string]int, window time.Duration) []messageAwareness {
+	messages := []messageAwareness{}
+	first := map[string]int{} // index of each sender's message 0
+	for _, sender := range senders {
+		first[sender] = len(messages)
+		for seq := 0; seq < sent[sender]; seq++ {
+			messages = append(messages, messageAwareness{broadcastMessage: broadcastMessage{sender, int64(seq)}})
+		}
+	}
+	for _, vehicle := range vehicles {
+		aware := map[int]bool{}
+		for _, p := range logs[vehicle] {
+			i, ok := first[p.Source]
+			if !ok || p.Header.Seq < 0 || p.Header.Seq >= int64(sent[p.Source]) {
+				continue
+			}
+			i += int(p.Header.Seq)
+			if aware[i] {
+				continue
+			}
+			messages[i].sent = p.T1 + p.E1
+			if latency := p.EndToEnd(); latency <= window {
+				aware[i] = true
+				messages[i].receivers++
+				if latency > messages[i].latest {
+					messages[i].latest = latency
+				}
+			}
+		}
+	}
+	return messages
+}
+
+// Mean fraction of the vehicles that were aware of a message, and the
+// fraction of messages all of them were aware of.
+func summarizeAwareness(messages []messageAwareness, vehicles int) (float64, float64) {
+	if len(messages) == 0 || vehicles == 0 {
+		return 0, 0
+	}
+	sum, complete := 0.0, 0
+	for _, m := range messages {
+		sum += float64(m.receivers) / float64(vehicles)
+		if m.receivers == vehicles {
+			complete++
+		}
+	}
+	return sum / float64(len(messages)), float64(complete) / float64(len(messages))
+}
+
+func writeAwareness(filename string, messages []messageAwareness, vehicles int) error {
+	rows := [][]string{}
+	for _, m := range messages {
+		rows = append(rows, []string{
+			m.source,
+			strconv.FormatInt(m.seq, 10),
+			strconv.FormatInt(m.sent, 10),
+			strconv.Itoa(m.receivers),
+			strconv.FormatFloat(float64(m.receivers)/float64(vehicles), 'f', -1, 64),
+			strconv.FormatFloat(ms(m.latest), 'f', -1, 64),
+		})
+	}
+	return writeCSV(filename, []string{"source", "seq", "sent", "receivers", "awareness", "latest"}, rows)
+}
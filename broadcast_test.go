@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroadcastAwareness(t *testing.T) {
+	received := func(seq int64, arrived, latency time.Duration) Packet {
+		p := Packet{Source: "rsu"}
+		p.Header.Seq = seq
+		p.T4 = int64(arrived)
+		p.T1 = p.T4 - int64(latency)
+		return p
+	}
+	logs := map[string][]Packet{
+		"car1": {
+			received(0, 0, 10*time.Millisecond),
+			received(1, 100*time.Millisecond, 20*time.Millisecond),
+			received(2, 200*time.Millisecond, 30*time.Millisecond),
+			received(3, 300*time.Millisecond, 40*time.Millisecond),
+		},
+		"car2": {
+			received(0, 0, 50*time.Millisecond),
+			received(2, 200*time.Millisecond, 200*time.Millisecond), // too late
+			received(2, 210*time.Millisecond, 200*time.Millisecond), // duplicate
+		},
+	}
+	vehicles := []string{"car1", "car2"}
+
+	sent := map[string]int{"rsu": 4}
+	pdr, irt, maxIRT := receptionStats(logs["car1"], sent)
+	if pdr != 1 || !approx(irt[0], 100) || !approx(maxIRT, 100) {
+		t.Errorf("car1 has PDR %v, IRT %v, max %v", pdr, irt, maxIRT)
+	}
+	pdr, irt, maxIRT = receptionStats(logs["car2"], sent)
+	if pdr != 0.5 || !approx(irt[0], 200) || !approx(maxIRT, 200) {
+		t.Errorf("car2 has PDR %v, IRT %v, max %v", pdr, irt, maxIRT)
+	}
+
+	messages := broadcastAwareness(vehicles, logs, []string{"rsu"}, sent, 100*time.Millisecond)
+	receivers := []int{}
+	for _, m := range messages {
+		receivers = append(receivers, m.receivers)
+	}
+	if len(messages) != 4 || receivers[0] != 2 || receivers[1] != 1 || receivers[2] != 1 || receivers[3] != 1 {
+		t.Fatalf("receivers per message are %v", receivers)
+	}
+	if messages[0].latest != 50*time.Millisecond {
+		t.Errorf("latest of message 0 is %v", messages[0].latest)
+	}
+	awareness, complete := summarizeAwareness(messages, len(vehicles))
+	if !approx(awareness, 0.625) || complete != 0.25 {
+		t.Errorf("awareness is %v, complete %v", awareness, complete)
+	}
+}
+
+// Two RSUs number their messages alike, which are still told apart.
+func TestBroadcastSenders(t *testing.T) {
+	received := func(source string, seq int64) Packet {
+		p := Packet{Source: source, T1: seq * int64(100*time.Millisecond)}
+		p.Header.Seq = seq
+		p.T4 = p.T1 + int64(10*time.Millisecond)
+		return p
+	}
+	logs := map[string][]Packet{
+		"car1": {received("rsu1", 0), received("rsu2", 0), received("rsu1", 1), received("rsu2", 1)},
+		"car2": {received("rsu1", 0), received("rsu1", 1), received("rsu2", 1)},
+	}
+	sent := map[string]int{"rsu1": 2, "rsu2": 2}
+	if pdr, _, _ := receptionStats(logs["car1"], sent); pdr != 1 {
+		t.Errorf("car1 has PDR %v", pdr)
+	}
+	if pdr, _, _ := receptionStats(logs["car2"], sent); pdr != 0.75 {
+		t.Errorf("car2 has PDR %v", pdr)
+	}
+
+	messages := broadcastAwareness([]string{"car1", "car2"}, logs, []string{"rsu1", "rsu2"}, sent, 100*time.Millisecond)
+	if len(messages) != 4 || messages[2].source != "rsu2" || messages[2].seq != 0 || messages[2].receivers != 1 || messages[3].receivers != 2 {
+		t.Fatalf("messages are %+v", messages)
+	}
+	if awareness, complete := summarizeAwareness(messages, 2); awareness != 0.875 || complete != 0.75 {
+		t.Errorf("awareness is %v, complete %v", awareness, complete)
+	}
+}
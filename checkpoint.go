@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var resumeFlag = flag.String("resume", "", "Resume an interrupted test suite from its log directory, e.g. logs/240101_1200")
+
+const checkpointFile = "progress.yml"
+
+// Flags that define a test suite, what its cases send, measure and write,
+// and must be the same when it is resumed.
+var suiteFlags = []string{
+	"cases", "rates", "sizes", "duration", "cooldown", "times", "impair", "vehicles", "sensors", "servers", "broadcast", "awareness",
+	"format", "deadline", "multicast", "replay", "replayBy",
+	"payload", "encoding", "signed", "cpmObjects",
+	"stream", "gop", "iRatio", "frameJitter", "chunk",
+}
+
+// Progress of a test suite, written to its log directory after each case.
+type Checkpoint struct {
+	StartTime string            `yaml:"start_time"`
+	Flags     map[string]string `yaml:"flags"`
+	Completed int               `yaml:"completed"`
+	Total     int               `yaml:"total"`
+	Updated   string            `yaml:"updated"`
+}
+
+func newCheckpoint(startTime string, total int) Checkpoint {
+	cp := Checkpoint{StartTime: startTime, Flags: map[string]string{}, Total: total}
+	for _, name := range suiteFlags {
+		cp.Flags[name] = flag.Lookup(name).Value.String()
+	}
+	return cp
+}
+
+func loadCheckpoint(dir string) (Checkpoint, error) {
+	var cp Checkpoint
+	data, err := ioutil.ReadFile(path.Join(dir, checkpointFile))
+	if err != nil {
+		return cp, err
+	}
+	err = yaml.Unmarshal(data, &cp)
+	return cp, err
+}
+
+// Overwrite the suite flags with the ones the checkpoint was made with.
+func (cp Checkpoint) restoreFlags() error {
+	for name, value := range cp.Flags {
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cp Checkpoint) save(dir string) error {
+	cp.Updated = time.Now().Format(time.RFC3339)
+	return writeYAML(path.Join(dir, checkpointFile), "", cp)
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+// A resumed suite takes the flags it was started with, whatever it is
+// resumed with.
+func TestCheckpointFlags(t *testing.T) {
+	setFlags(t, map[string]string{"format": "parquet", "stream": "video", "deadline": "50ms"})
+	dir := t.TempDir()
+	if err := newCheckpoint("240101_1200", 3).save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	setFlags(t, map[string]string{"format": "csv", "stream": "", "deadline": "100ms"})
+	cp, err := loadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.restoreFlags(); err != nil {
+		t.Fatal(err)
+	}
+	if *logFormatFlag != "parquet" || *streamFlag != "video" || deadlineFlag.Milliseconds() != 50 {
+		t.Errorf("flags are -format %s -stream %s -deadline %v", *logFormatFlag, *streamFlag, *deadlineFlag)
+	}
+}
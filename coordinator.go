@@ -1,11 +1,13 @@
 package main
 
 import (
-	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,11 +21,36 @@ var testCooldownFlag = flag.Duration("cooldown", 30*time.Second, "How long shoul
 var testRerunsFlag = flag.Uint("times", 1, "How many times should the test suite be run?")
 var switchManuallyFlag = flag.Bool("manual", false, "Should the tests be switched manually?")
 var enableVerboseFlag = flag.Bool("verbose", false, "Print information about the test cases")
+var requiredNodesFlag = flag.String("require", "sensor,server,vehicle", "Which node types or names must be online before testing?")
+var waitNodesFlag = flag.Duration("wait", 1*time.Minute, "How long should the coordinator wait for the required nodes?")
+var vehiclesFlag = flag.String("vehicles", "vehicle", "Names of the vehicles to collect logs from")
+var sensorsFlag = flag.String("sensors", "sensor", "Names of the sensors to configure for each case")
+var serversFlag = flag.String("servers", "server", "Names of the servers to configure for each case")
+
+// The nodes the coordinator runs the tests with.
+type Fleet struct {
+	Vehicles []string
+	Sensors  []string
+	Servers  []string
+}
 
 func coordinator() func(*Node) {
 
 	startTime := time.Now().Format("060102_1504")
 
+	var resumed Checkpoint
+	if len(*resumeFlag) != 0 {
+		cp, err := loadCheckpoint(*resumeFlag)
+		if err != nil {
+			panic(err)
+		}
+		if err := cp.restoreFlags(); err != nil {
+			panic(err)
+		}
+		startTime = cp.StartTime
+		resumed = cp
+	}
+
 	testCases := []int{}
 	if len(*testCasesFlag) != 0 {
 		for _, s := range strings.Split(*testCasesFlag, ",") {
@@ -57,6 +84,24 @@ func coordinator() func(*Node) {
 		}
 	}
 
+	testImpairments := []string{}
+	if len(*impairFlag) != 0 {
+		testImpairments = strings.Split(*impairFlag, ";")
+	}
+	testImpairUL := []map[string]int{}
+	testImpairDL := []map[string]int{}
+	for _, spec := range testImpairments {
+		ul, dl, err := parseImpairment(spec)
+		if err != nil {
+			panic(err)
+		}
+		if *broadcastFlag && hasDownlinkKeys(spec) {
+			panic(fmt.Sprintf("Impairment \"%s\" has dl. keys, but a broadcast has no downlink, use ul. or no prefix", spec))
+		}
+		testImpairUL = append(testImpairUL, ul)
+		testImpairDL = append(testImpairDL, dl)
+	}
+
 	testLoads := []int{} // [%]
 	testMobility := []bool{}
 	testFeatures := []string{}
@@ -185,149 +230,499 @@ func coordinator() func(*Node) {
 		panic(fmt.Sprintf("Number of test cases (%d) and sizes (%d) do not match", len(testCases), len(testSizes)))
 	}
 
+	if len(testImpairments) != 0 && len(testImpairments) != len(testCases) {
+		panic(fmt.Sprintf("Number of test cases (%d) and impairments (%d) do not match", len(testCases), len(testImpairments)))
+	}
+
 	testDuration := *testDurationFlag
 	testCooldown := *testCooldownFlag
 	testReruns := *testRerunsFlag
 	switchManually := *switchManuallyFlag
 	enableVerbose := *enableVerboseFlag
+	enableTUI := *enableTUIFlag
 
 	numRuns := len(testCases) * int(testReruns)
 
-	err := os.MkdirAll("logs", os.ModePerm)
+	logFormat := *logFormatFlag
+	logExt, err := logExtension(logFormat)
 	if err != nil {
 		panic(err)
 	}
 
+	err = os.MkdirAll("logs", os.ModePerm)
+	if err != nil {
+		panic(err)
+	}
+
+	requiredNodes := []string{}
+	if len(*requiredNodesFlag) != 0 {
+		requiredNodes = strings.Split(*requiredNodesFlag, ",")
+	}
+
+	fleet := Fleet{
+		Vehicles: splitNames(*vehiclesFlag),
+		Sensors:  splitNames(*sensorsFlag),
+		Servers:  splitNames(*serversFlag),
+	}
+	if *broadcastFlag {
+		fleet.Servers = nil // the RSUs send to the vehicles directly
+	}
+	if len(fleet.Vehicles) == 0 || len(fleet.Sensors) == 0 || (len(fleet.Servers) == 0 && !*broadcastFlag) {
+		panic("At least one vehicle, sensor and server is needed")
+	}
+
 	return func(node *Node) {
-		// clean log at vehicle
-		node.remote_set_log("vehicle", []Packet{})
+		nodes, err := waitForNodes(node, requiredNodes, *waitNodesFlag)
+		if err != nil {
+			fmt.Printf("Cannot start tests, %v\n", err)
+			node.setAttr("alive", 0)
+			return
+		}
+		for _, hb := range nodes {
+			fmt.Printf("Found %s (%s, version %s) on %s\n", hb.Name, hb.Type, hb.Version, hb.Host)
+		}
+
+		var dash *Dashboard
+		if enableTUI {
+			dash, err = NewDashboard(node)
+			if err != nil {
+				panic(err)
+			}
+			dash.setState("Checking connection", 5*time.Second, 0, numRuns)
+		}
+		closeDash := func() {
+			if dash != nil {
+				dash.stop()
+				dash = nil
+			}
+		}
+		defer closeDash()
+		status := func(format string, a ...interface{}) {
+			if dash != nil {
+				dash.note(format, a...)
+			} else {
+				fmt.Printf("\n"+format+"\n", a...)
+			}
+		}
+
+		// First interrupt finishes the current case, the second aborts it
+		interrupt := make(chan os.Signal, 2)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+		stop := make(chan struct{})
+		abort := make(chan struct{})
+		go func() {
+			<-interrupt
+			status("Interrupted, finishing current case. Press Ctrl-C again to abort it.")
+			close(stop)
+			<-interrupt
+			status("Aborting current case.")
+			close(abort)
+		}()
+		stopping := func() bool {
+			select {
+			case <-stop:
+				return true
+			default:
+				return false
+			}
+		}
 
-		checkConnection(node)
+		// clean logs at vehicles
+		err = fleet.clearLogs(node)
+		if err == nil {
+			err = checkConnection(node, fleet)
+		}
+		if err != nil {
+			closeDash()
+			fmt.Printf("Cannot start tests, %v\n", err)
+			node.setAttr("alive", 0)
+			return
+		}
 		time.Sleep(1 * time.Second)
 
 		logDir := path.Join("logs", startTime)
-		os.MkdirAll(logDir, os.ModePerm)
-		flagsFile, err := os.Create(path.Join(logDir, "flags.yml"))
-		if err != nil {
+		checkpoint := newCheckpoint(startTime, numRuns)
+		manifest := Manifest{
+			SchemaVersion: manifestSchemaVersion,
+			StartTime:     startTime,
+			Suite: SuiteParams{
+				Cases:    testCases,
+				Rates:    testRates,
+				Sizes:    testSizes,
+				Duration: testDuration.Seconds(),
+				Cooldown: testCooldown.Seconds(),
+				Reruns:   testReruns,
+				Manual:   switchManually,
+				Required: requiredNodes,
+				Format:   logFormat,
+				Impair:   testImpairments,
+			},
+			Environment: currentEnvironment(),
+			Cases:       []CaseResult{},
+		}
+		manifest.Environment.Nodes = nodeInfos(nodes)
+		if len(fleet.Vehicles) > 1 || len(fleet.Sensors) > 1 || len(fleet.Servers) > 1 {
+			manifest.Suite.Vehicles = fleet.Vehicles
+			manifest.Suite.Sensors = fleet.Sensors
+			manifest.Suite.Servers = fleet.Servers
+		}
+		if len(*resumeFlag) != 0 {
+			logDir = *resumeFlag
+			checkpoint.Completed = resumed.Completed
+			previous, err := loadManifest(logDir)
+			if err != nil {
+				panic(err)
+			}
+			if len(previous.Cases) > checkpoint.Completed {
+				previous.Cases = previous.Cases[:checkpoint.Completed]
+			}
+			manifest.Cases = previous.Cases
+			fmt.Printf("Resuming tests after %d/%d case(s)! They will be stored here: %s\n", checkpoint.Completed, numRuns, logDir)
+		} else {
+			os.MkdirAll(logDir, os.ModePerm)
+			fmt.Printf("Starting tests! They will be stored here: %s\n", logDir)
+		}
+		if err := manifest.save(logDir); err != nil {
+			panic(err)
+		}
+		if err := checkpoint.save(logDir); err != nil {
 			panic(err)
 		}
-		defer flagsFile.Close()
-		flagsFile.WriteString(fmt.Sprintf("# Test suite started at %s with following test(s): %s\n", startTime, *testCasesFlag))
 
-		fmt.Printf("Starting tests! They will be stored here: %s\n", logDir)
+		// Stop the suite early, it can be continued with -resume
+		halt := func(reason string) {
+			closeDash()
+			if err := checkpoint.save(logDir); err != nil {
+				fmt.Printf("Failed to save checkpoint: %v\n", err)
+			}
+			fmt.Printf("(%d/%d)\nTests stopped, %s. Continue with: -resume %s\n", checkpoint.Completed, numRuns, reason, logDir)
+			node.setAttr("alive", 0)
+		}
 
 		progress := 0
 		for n := 0; n < int(testReruns); n++ {
 			for i := 0; i < len(testCases); i++ {
+				if progress < checkpoint.Completed {
+					progress++
+					continue
+				}
+				if stopping() {
+					halt("interrupted")
+					return
+				}
+
 				if switchManually {
-					fmt.Printf("Press enter to continue with next case: TC%d", testCases[i])
+					if dash != nil {
+						dash.setState(fmt.Sprintf("Press enter to continue with next case: TC%d", testCases[i]), 0, progress, numRuns)
+					} else {
+						fmt.Printf("Press enter to continue with next case: TC%d", testCases[i])
+					}
 					fmt.Scanln()
 					fmt.Println("")
-				} else if progress != 0 {
-					time.Sleep(testCooldown)
+				} else if progress != resumed.Completed {
+					if dash != nil {
+						dash.setState("Cooldown", testCooldown, progress, numRuns)
+					}
+					select {
+					case <-time.After(testCooldown):
+					case <-stop:
+						halt("interrupted")
+						return
+					}
 				}
 
-				timeNow := time.Now().Format("060102_1504")
-				fileName := fmt.Sprintf("%s__TC%d.csv", timeNow, testCases[i])
+				started := time.Now()
+				timeNow := started.Format("060102_1504")
+				fileName := fmt.Sprintf("%s__TC%d%s", timeNow, testCases[i], logExt)
 				filePath := path.Join(logDir, fileName)
 
-				if enableVerbose {
-					offset := node.ntpClient.Resp.ClockOffset.Milliseconds()
+				if dash != nil {
+					dash.setState(fmt.Sprintf("Running TC%d", testCases[i]), testDuration, progress, numRuns)
+				} else if enableVerbose {
+					offset := time.Duration(node.ntpClient.GetOffset()).Milliseconds()
 					fmt.Printf("(%d/%d) Running TC%d - NTP offset %d ms\r", progress, numRuns, testCases[i], offset)
 				} else {
 					fmt.Printf("(%d/%d) Running TC%d\r", progress, numRuns, testCases[i])
 				}
 
 				// Set the test case configuration
-				node.remote_set("sensor", "rate", testRates[i])
-				node.remote_set("sensor", "DATA_SIZE", testSizes[i])
-				node.remote_set("sensor", "DATA_SEQ", 0)
-				node.remote_set("server", "COMPUTE_TIME", 0)
+				var ul, dl map[string]int
+				if len(testImpairments) != 0 {
+					ul, dl = testImpairUL[i], testImpairDL[i]
+				}
+				if err := fleet.configure(node, testRates[i], testSizes[i], ul, dl); err != nil {
+					halt(fmt.Sprintf("TC%d could not be configured, %v", testCases[i], err))
+					return
+				}
 
-				// Run the actual test
-				runTest(node, testDuration)
+				// Run the actual test, from and until when in NTP corrected time
+				caseStart := time.Now().UnixNano() + node.ntpClient.GetOffset()
+				completed, err := runTest(node, fleet, testDuration, abort)
+				caseEnd := time.Now().UnixNano() + node.ntpClient.GetOffset()
+				if err != nil {
+					halt(fmt.Sprintf("TC%d could not be run, %v", testCases[i], err))
+					return
+				}
+				if !completed {
+					fleet.clearLogs(node)
+					halt(fmt.Sprintf("TC%d was aborted", testCases[i]))
+					return
+				}
 
-				// Retreive the log and save it
-				log, err := node.remote_get_log("vehicle")
+				// Retreive the logs and save them, merged and per vehicle
+				logs, err := fleet.collectLogs(node)
+				if err != nil {
+					halt(fmt.Sprintf("could not get log of TC%d %v", testCases[i], err))
+					return
+				}
+				var sent map[string]int
+				if *broadcastFlag {
+					if sent, err = fleet.sentBy(node, "DATA_SEQ"); err != nil {
+						halt(fmt.Sprintf("could not get the packets sent in TC%d %v", testCases[i], err))
+						return
+					}
+				}
+				frames, err := fleet.sentBy(node, "FRAME_SEQ")
 				if err != nil {
-					panic(err)
+					halt(fmt.Sprintf("could not get the frames sent in TC%d %v", testCases[i], err))
+					return
 				}
-				node.remote_set_log("vehicle", []Packet{})
+				log := mergeLogs(fleet.Vehicles, logs)
 				save(log, filePath)
+				vehicles := []VehicleResult{}
+				for _, vehicle := range fleet.Vehicles {
+					result := VehicleResult{Name: vehicle, Packets: len(logs[vehicle])}
+					if len(fleet.Vehicles) > 1 {
+						result.Filename = fmt.Sprintf("%s__TC%d__%s%s", timeNow, testCases[i], vehicle, logExt)
+						save(logs[vehicle], path.Join(logDir, result.Filename))
+					}
+					stats := VehicleStats{}
+					summarizePackets(&stats, logs[vehicle])
+					result.Rate, result.Loss = stats.Rate, stats.Loss
+					result.UL, result.DL, result.E2E = stats.UL, stats.DL, stats.E2E
+					result.Missed, result.Late, result.AoI, result.PeakAoI = stats.Missed, stats.Late, stats.AoI, stats.PeakAoI
+					if *broadcastFlag {
+						var irt [3]float64
+						result.PDR, irt, result.MaxIRT = receptionStats(logs[vehicle], sent)
+						result.IRT = irt[:]
+					}
+					if result.Stream = frameStats(logs[vehicle], frames); result.Stream != nil {
+						status("TC%d %s: %d frames, %.1f%% lost, frame latency p50 %.1f ms p95 %.1f ms", testCases[i], vehicle, result.Stream.Frames, 100*result.Stream.Loss, result.Stream.Latency[0], result.Stream.Latency[1])
+					}
+					vehicles = append(vehicles, result)
+					if len(fleet.Vehicles) > 1 {
+						status("TC%d %s: %d packets, %.1f%% lost, e2e p50 %.1f ms p95 %.1f ms", testCases[i], vehicle, result.Packets, 100*result.Loss, result.E2E[0], result.E2E[1])
+					}
+				}
 
-				// Write flags to file
-				flagsFile.WriteString(fmt.Sprintf("- case: %d\n", testCases[i]))
-				flagsFile.WriteString(fmt.Sprintf("  rate: %d\n", testRates[i]))
-				flagsFile.WriteString(fmt.Sprintf("  size: %d\n", testSizes[i]))
-				flagsFile.WriteString(fmt.Sprintf("  load: %d\n", testLoads[i]))
-				flagsFile.WriteString(fmt.Sprintf("  mobility: %t\n", testMobility[i]))
-				flagsFile.WriteString(fmt.Sprintf("  features: \"%s\"\n", testFeatures[i]))
-				flagsFile.WriteString(fmt.Sprintf("  datetime: \"%s\"\n", timeNow))
-				flagsFile.WriteString(fmt.Sprintf("  duration: %f\n", testDuration.Seconds()))
-				flagsFile.WriteString(fmt.Sprintf("  cooldown: %f\n", testCooldown.Seconds()))
-				flagsFile.WriteString(fmt.Sprintf("  filename: %s\n", fileName))
+				// Record the case in the manifest
+				manifest.Cases = append(manifest.Cases, CaseResult{
+					Case:     testCases[i],
+					Rate:     testRates[i],
+					Size:     testSizes[i],
+					Load:     testLoads[i],
+					Mobility: testMobility[i],
+					Features: testFeatures[i],
+					Datetime: timeNow,
+					Duration: testDuration.Seconds(),
+					Cooldown: testCooldown.Seconds(),
+					Filename: fileName,
+					Run:      n,
+					Started:  started.Format(time.RFC3339),
+					Finished: time.Now().Format(time.RFC3339),
+					Packets:  len(log),
+					Vehicles: vehicles,
+				})
+				result := &manifest.Cases[len(manifest.Cases)-1]
+				if len(testImpairments) != 0 {
+					result.Impairment = testImpairments[i]
+				}
+				result.AoIFile = fmt.Sprintf("%s__TC%d_aoi.csv", timeNow, testCases[i])
+				if err := writeAoI(path.Join(logDir, result.AoIFile), fleet.Vehicles, logs); err != nil {
+					fmt.Printf("Failed to save age of information: %v\n", err)
+				}
+				for _, vehicle := range fleet.Vehicles {
+					handovers, err := node.remote_get_handovers(vehicle)
+					if err != nil {
+						continue
+					}
+					for _, h := range handovers {
+						if h.Stamp >= caseStart && h.Stamp <= caseEnd {
+							if len(fleet.Vehicles) > 1 {
+								h.Vehicle = vehicle
+							}
+							result.Handovers = append(result.Handovers, h)
+						}
+					}
+				}
+				if *broadcastFlag {
+					messages := broadcastAwareness(fleet.Vehicles, logs, fleet.Sensors, sent, *awarenessWindow)
+					b := &BroadcastResult{
+						Senders:  fleet.Sensors,
+						Sent:     len(messages),
+						Window:   ms(*awarenessWindow),
+						Filename: fmt.Sprintf("%s__TC%d_awareness.csv", timeNow, testCases[i]),
+					}
+					b.Awareness, b.Complete = summarizeAwareness(messages, len(fleet.Vehicles))
+					if err := writeAwareness(path.Join(logDir, b.Filename), messages, len(fleet.Vehicles)); err != nil {
+						fmt.Printf("Failed to save awareness: %v\n", err)
+					}
+					result.Broadcast = b
+					status("TC%d: %d sent, %.1f%% awareness within %v, %.1f%% reached all vehicles", testCases[i], b.Sent, 100*b.Awareness, *awarenessWindow, 100*b.Complete)
+				}
+				if err := manifest.save(logDir); err != nil {
+					fmt.Printf("Failed to save manifest: %v\n", err)
+				}
 
 				progress++
+				checkpoint.Completed = progress
+				if err := checkpoint.save(logDir); err != nil {
+					fmt.Printf("Failed to save checkpoint: %v\n", err)
+				}
 			}
 		}
 
 		// Pause the testing (cause main to stop running)
-		fmt.Printf("(%d/%d)\nTests finished!", progress, numRuns)
-		node.attr["paused"] = 1
+		closeDash()
+		fmt.Printf("(%d/%d)\nTests finished!\n", progress, numRuns)
+		node.setAttr("paused", 1)
 	}
 }
 
-func checkConnection(node *Node) {
-	runTest(node, 5*time.Second)
-	log, err := node.remote_get_log("vehicle")
+func checkConnection(node *Node, fleet Fleet) error {
+	if _, err := runTest(node, fleet, 5*time.Second, nil); err != nil {
+		return err
+	}
+	logs, err := fleet.collectLogs(node)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	if len(log) == 0 {
-		panic("Data is not coming through!")
+	for _, vehicle := range fleet.Vehicles {
+		if len(logs[vehicle]) == 0 {
+			return fmt.Errorf("data is not coming through to \"%s\"", vehicle)
+		}
 	}
-	node.remote_set_log("vehicle", []Packet{})
+	return nil
 }
 
-func runTest(n *Node, test_duration time.Duration) {
-	n.unpause("vehicle", "server", "sensor")
-	time.Sleep(test_duration)
-	n.pause("server", "sensor")
+// Give the sensors and servers the settings of a case, with the uplink and
+// downlink impairments if any.
+func (f Fleet) configure(n *Node, rate, size int, ul, dl map[string]int) error {
+	set := func(remote, name string, value int) error {
+		if _, err := n.remote_set(remote, name, value); err != nil {
+			return fmt.Errorf("\"%s\" refused %s = %d (%v)", remote, name, value, err)
+		}
+		return nil
+	}
+	for _, sensor := range f.Sensors {
+		settings := []struct {
+			name  string
+			value int
+		}{{"rate", rate}, {"DATA_SIZE", size}, {"DATA_SEQ", 0}}
+		for _, setting := range settings {
+			if err := set(sensor, setting.name, setting.value); err != nil {
+				return err
+			}
+		}
+		if resp, err := n.remote_get(sensor, "FRAME_SEQ"); err == nil && resp.Success { // it streams
+			if err := set(sensor, "FRAME_SEQ", 0); err != nil {
+				return err
+			}
+		}
+		for name, value := range ul {
+			if err := set(sensor, name, value); err != nil {
+				return err
+			}
+		}
+	}
+	for _, server := range f.Servers {
+		if err := set(server, "COMPUTE_TIME", 0); err != nil {
+			return err
+		}
+		for name, value := range dl {
+			if err := set(server, name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// How many packets or frames each sensor sent, by its DATA_SEQ or FRAME_SEQ,
+// for the sensors that have it.
+func (f Fleet) sentBy(n *Node, attr string) (map[string]int, error) {
+	sent := map[string]int{}
+	for _, sensor := range f.Sensors {
+		resp, err := n.remote_get(sensor, attr)
+		if err != nil {
+			return nil, fmt.Errorf("from \"%s\" (%v)", sensor, err)
+		}
+		if resp.Success {
+			sent[sensor] = resp.Data
+		}
+	}
+	return sent, nil
+}
+
+// Run a single test, returns false if it was aborted before the duration
+// passed, and an error if a node could not be paused or unpaused.
+func runTest(n *Node, fleet Fleet, test_duration time.Duration, abort <-chan struct{}) (bool, error) {
+	completed := true
+	for _, nodes := range [][]string{fleet.Vehicles, fleet.Servers, fleet.Sensors} {
+		if err := n.unpause(nodes...); err != nil {
+			n.pause(fleet.Sensors...)
+			n.pause(fleet.Servers...)
+			n.pause(fleet.Vehicles...)
+			return false, err
+		}
+	}
+	select {
+	case <-time.After(test_duration):
+	case <-abort:
+		completed = false
+	}
+	errs := []error{n.pause(fleet.Servers...), n.pause(fleet.Sensors...)}
 	time.Sleep(time.Duration(1))
-	n.pause("vehicle") // to give enough time for the vehicle to send the trailing messages
+	errs = append(errs, n.pause(fleet.Vehicles...)) // to give enough time for the vehicles to send the trailing messages
+	return completed, errors.Join(errs...)
 }
 
-func save(log []Packet, filename string) {
+func (f Fleet) clearLogs(n *Node) error {
+	for _, vehicle := range f.Vehicles {
+		if _, err := n.remote_set_log(vehicle, []Packet{}); err != nil {
+			return fmt.Errorf("could not clear the log of \"%s\" (%v)", vehicle, err)
+		}
+	}
+	return nil
+}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		panic(err)
+// Get and clear the log of every vehicle.
+func (f Fleet) collectLogs(n *Node) (map[string][]Packet, error) {
+	logs := map[string][]Packet{}
+	for _, vehicle := range f.Vehicles {
+		log, err := n.remote_get_log(vehicle)
+		if err != nil {
+			return nil, fmt.Errorf("from \"%s\" (%v)", vehicle, err)
+		}
+		logs[vehicle] = log
+	}
+	if err := f.clearLogs(n); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// All vehicle logs in one, in order of arrival. The frame_id column tells
+// the vehicles apart.
+func mergeLogs(vehicles []string, logs map[string][]Packet) []Packet {
+	if len(vehicles) == 1 {
+		return logs[vehicles[0]]
 	}
-	defer file.Close()
-
-	csvwrite := csv.NewWriter(file)
-	defer csvwrite.Flush()
-
-	csvwrite.Write([]string{"t1", "t2", "t3", "t4", "e1", "e2", "e3", "e4", "x", "y", "yaw", "vel", "lat", "lon", "seq", "valid", "frame_id"})
-	for _, packet := range log {
-		t1 := strconv.FormatInt(packet.T1, 10)
-		t2 := strconv.FormatInt(packet.T2, 10)
-		t3 := strconv.FormatInt(packet.T3, 10)
-		t4 := strconv.FormatInt(packet.T4, 10)
-		e1 := strconv.FormatInt(packet.E1, 10)
-		e2 := strconv.FormatInt(packet.E2, 10)
-		e3 := strconv.FormatInt(packet.E3, 10)
-		e4 := strconv.FormatInt(packet.E4, 10)
-		x := strconv.FormatFloat(packet.X, 'f', -1, 64)
-		y := strconv.FormatFloat(packet.Y, 'f', -1, 64)
-		yaw := strconv.FormatFloat(packet.Yaw, 'f', -1, 64)
-		vel := strconv.FormatFloat(float64(packet.V), 'f', -1, 64)
-		lat := strconv.FormatFloat(packet.Latitude, 'f', -1, 64)
-		lon := strconv.FormatFloat(packet.Longitude, 'f', -1, 64)
-		seq := strconv.FormatInt(packet.Header.Seq, 10)
-		chk := strconv.Itoa(packet.Chk)
-		frame_id := packet.Header.FrameID
-
-		csvwrite.Write([]string{t1, t2, t3, t4, e1, e2, e3, e4, x, y, yaw, vel, lat, lon, seq, chk, frame_id})
+	merged := []Packet{}
+	for _, vehicle := range vehicles {
+		merged = append(merged, logs[vehicle]...)
 	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].T4 < merged[j].T4 })
+	return merged
 }
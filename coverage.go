@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const coverageUsage = `Usage: wp3go coverage [-tile m | -route file [-bin m] [-offRoute m]] [-origin lat,lon] [-metric e2e|ul|dl] [-min ms] [-max ms] [-o name] <logs dir or file>...
+
+Bins the packets of all runs found in the given directories by square tiles
+of -tile meters, or by -bin meters along a route from a GPX or CSV file, and
+writes the latency percentiles, loss and sample count of each bin to
+<name>.csv and a heatmap coloured by p95 latency to <name>.geojson.
+`
+
+// A tile (x, y) east and north of the origin, or a bin (x, 0) along a route.
+type coverageKey struct {
+	x, y int
+}
+
+// How packets are binned, and what the bins look like on a map.
+type coverageBins interface {
+	bin(lat, lon float64) (coverageKey, bool)
+	header() []string
+	columns(key coverageKey) []string
+	geometry(key coverageKey) GeoJSONGeometry
+}
+
+// Square tiles on a grid anchored at a fixed origin, so that maps of
+// different runs line up.
+type tileBins struct {
+	lat0, lon0, size float64
+}
+
+func (t tileBins) bin(lat, lon float64) (coverageKey, bool) {
+	x, y := toLocal(t.lat0, t.lon0, lat, lon)
+	return coverageKey{int(math.Floor(x / t.size)), int(math.Floor(y / t.size))}, true
+}
+
+func (t tileBins) header() []string {
+	return []string{"tile_x", "tile_y", "lat", "lon"}
+}
+
+func (t tileBins) columns(key coverageKey) []string {
+	lat, lon := toGeo(t.lat0, t.lon0, (float64(key.x)+0.5)*t.size, (float64(key.y)+0.5)*t.size)
+	return []string{strconv.Itoa(key.x), strconv.Itoa(key.y), fmt.Sprint(lat), fmt.Sprint(lon)}
+}
+
+func (t tileBins) geometry(key coverageKey) GeoJSONGeometry {
+	ring := [][]float64{}
+	for _, corner := range [][2]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}} {
+		lat, lon := toGeo(t.lat0, t.lon0, float64(key.x+corner[0])*t.size, float64(key.y+corner[1])*t.size)
+		ring = append(ring, []float64{lon, lat})
+	}
+	return GeoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{ring}}
+}
+
+// Bins of `size` meters along a route. Packets further than `offRoute` from
+// it are left out.
+type routeBins struct {
+	points         []pathPoint
+	along          []float64 // distance of each point from the start
+	lat0, lon0     float64
+	size, offRoute float64
+}
+
+func newRouteBins(file string, size, offRoute, lat0, lon0 float64) (*routeBins, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var points []pathPoint
+	if strings.HasSuffix(strings.ToLower(file), ".gpx") {
+		points, lat0, lon0, err = loadGPX(f, 1)
+	} else {
+		points, lat0, lon0, err = loadPathCSV(f, 1, lat0, lon0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("%s: a route needs at least two points", file)
+	}
+	r := &routeBins{points: points, along: []float64{0}, lat0: lat0, lon0: lon0, size: size, offRoute: offRoute}
+	for i := 1; i < len(points); i++ {
+		d := math.Hypot(points[i].x-points[i-1].x, points[i].y-points[i-1].y)
+		r.along = append(r.along, r.along[i-1]+d)
+	}
+	return r, nil
+}
+
+// Distance along the route of the closest point on it, and how far that is.
+func (r *routeBins) project(x, y float64) (float64, float64) {
+	best, bestAlong := math.Inf(1), 0.0
+	for i := 1; i < len(r.points); i++ {
+		a, b := r.points[i-1], r.points[i]
+		dx, dy := b.x-a.x, b.y-a.y
+		f := 0.0
+		if l := dx*dx + dy*dy; l > 0 {
+			f = math.Max(0, math.Min(1, ((x-a.x)*dx+(y-a.y)*dy)/l))
+		}
+		if d := math.Hypot(a.x+f*dx-x, a.y+f*dy-y); d < best {
+			best, bestAlong = d, r.along[i-1]+f*(r.along[i]-r.along[i-1])
+		}
+	}
+	return bestAlong, best
+}
+
+// The point `along` meters from the start of the route.
+func (r *routeBins) at(along float64) (float64, float64) {
+	i := sort.SearchFloat64s(r.along, along)
+	if i == 0 {
+		return r.points[0].x, r.points[0].y
+	}
+	if i == len(r.along) {
+		last := r.points[len(r.points)-1]
+		return last.x, last.y
+	}
+	a, b := r.points[i-1], r.points[i]
+	f := 0.0
+	if d := r.along[i] - r.along[i-1]; d > 0 {
+		f = (along - r.along[i-1]) / d
+	}
+	return a.x + f*(b.x-a.x), a.y + f*(b.y-a.y)
+}
+
+func (r *routeBins) bin(lat, lon float64) (coverageKey, bool) {
+	along, off := r.project(toLocal(r.lat0, r.lon0, lat, lon))
+	if off > r.offRoute {
+		return coverageKey{}, false
+	}
+	bin := int(along / r.size)
+	if bin > 0 && float64(bin)*r.size >= r.along[len(r.along)-1] {
+		bin-- // at the very end
+	}
+	return coverageKey{bin, 0}, true
+}
+
+func (r *routeBins) header() []string {
+	return []string{"from", "to", "lat", "lon"}
+}
+
+func (r *routeBins) span(key coverageKey) (float64, float64) {
+	from := float64(key.x) * r.size
+	return from, math.Min(from+r.size, r.along[len(r.along)-1])
+}
+
+func (r *routeBins) columns(key coverageKey) []string {
+	from, to := r.span(key)
+	x, y := r.at((from + to) / 2)
+	lat, lon := toGeo(r.lat0, r.lon0, x, y)
+	return []string{fmt.Sprint(from), fmt.Sprint(to), fmt.Sprint(lat), fmt.Sprint(lon)}
+}
+
+func (r *routeBins) geometry(key coverageKey) GeoJSONGeometry {
+	from, to := r.span(key)
+	line := [][]float64{}
+	add := func(x, y float64) {
+		lat, lon := toGeo(r.lat0, r.lon0, x, y)
+		line = append(line, []float64{lon, lat})
+	}
+	add(r.at(from))
+	for i, along := range r.along {
+		if along > from && along < to {
+			add(r.points[i].x, r.points[i].y)
+		}
+	}
+	add(r.at(to))
+	return GeoJSONGeometry{Type: "LineString", Coordinates: line}
+}
+
+// Packets of all runs in one bin.
+type coverageBin struct {
+	latencies []float64 // [ms]
+	lost      int64
+	runs      map[string]bool
+}
+
+type Coverage struct {
+	bins   coverageBins
+	metric string
+	cells  map[coverageKey]*coverageBin
+}
+
+func NewCoverage(bins coverageBins, metric string) *Coverage {
+	return &Coverage{bins: bins, metric: metric, cells: map[coverageKey]*coverageBin{}}
+}
+
+// Add the packets of a run. Packets lost in between count against the bin of
+// the next packet with a position. Returns how many packets were binned.
+func (c *Coverage) add(run string, log []Packet) int {
+	binned := 0
+	for _, p := range geoPackets(log) {
+		key, ok := c.bins.bin(p.Latitude, p.Longitude)
+		if !ok {
+			continue
+		}
+		cell, ok := c.cells[key]
+		if !ok {
+			cell = &coverageBin{runs: map[string]bool{}}
+			c.cells[key] = cell
+		}
+		cell.latencies = append(cell.latencies, ms(latencyMetrics[c.metric](&p.Packet)))
+		cell.lost += p.lost
+		cell.runs[run] = true
+		binned++
+	}
+	return binned
+}
+
+func (c *Coverage) keys() []coverageKey {
+	keys := make([]coverageKey, 0, len(c.cells))
+	for key := range c.cells {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].x != keys[j].x {
+			return keys[i].x < keys[j].x
+		}
+		return keys[i].y < keys[j].y
+	})
+	return keys
+}
+
+// Statistics of a bin, in the order of `coverageColumns`.
+var coverageColumns = []string{"samples", "lost", "loss", "runs", "p50", "p95", "p99", "mean"}
+
+func (b *coverageBin) stats() []float64 {
+	samples := len(b.latencies)
+	sum := 0.0
+	for _, latency := range b.latencies {
+		sum += latency
+	}
+	p := latencyPercentiles(b.latencies)
+	loss := float64(b.lost) / float64(int64(samples)+b.lost)
+	return []float64{float64(samples), float64(b.lost), loss, float64(len(b.runs)), p[0], p[1], p[2], sum / float64(samples)}
+}
+
+func (c *Coverage) writeGrid(filename string) error {
+	rows := [][]string{}
+	for _, key := range c.keys() {
+		record := c.bins.columns(key)
+		for _, v := range c.cells[key].stats() {
+			record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		rows = append(rows, record)
+	}
+	return writeCSV(filename, append(c.bins.header(), coverageColumns...), rows)
+}
+
+func (c *Coverage) writeHeatmap(filename string, min, max float64) error {
+	doc := GeoJSON{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for _, key := range c.keys() {
+		stats := c.cells[key].stats()
+		properties := map[string]interface{}{"metric": c.metric}
+		for i, name := range coverageColumns {
+			properties[name] = stats[i]
+		}
+		color := hexColor(latencyColor(stats[5], min, max))
+		geometry := c.bins.geometry(key)
+		if geometry.Type == "Polygon" {
+			properties["fill"] = color
+			properties["fill-opacity"] = 0.6
+			properties["stroke-width"] = 0
+		} else {
+			properties["stroke"] = color
+			properties["stroke-width"] = 6
+		}
+		doc.Features = append(doc.Features, GeoJSONFeature{Type: "Feature", Geometry: geometry, Properties: properties})
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(doc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// The packet logs of all cases of all suites under `target`, or `target`
+// itself if it is a log file.
+func findLogs(target string) ([]string, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{target}, nil
+	}
+	logs := []string{}
+	err = filepath.WalkDir(target, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		if _, err := os.Stat(path.Join(dir, manifestFile)); err != nil {
+			return nil
+		}
+		manifest, err := loadManifest(dir)
+		if err != nil {
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+		for _, result := range manifest.Cases {
+			logs = append(logs, caseLog(dir, result))
+		}
+		return nil
+	})
+	return logs, err
+}
+
+// Aggregate packet logs into a coverage map. Returns the exit code.
+func coverage(args []string) int {
+	fs := flag.NewFlagSet("coverage", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), coverageUsage) }
+	tile := fs.Float64("tile", 20, "Size of the tiles [m].")
+	route := fs.String("route", "", "GPX or CSV file of a route to bin along, instead of tiles.")
+	bin := fs.Float64("bin", 10, "Length of the bins along the route [m].")
+	offRoute := fs.Float64("offRoute", 25, "Leave out packets further than this from the route [m].")
+	origin := fs.String("origin", "59.3500,18.0700", "Corner of the tile grid (lat,lon), keep it the same to compare maps.")
+	metric := fs.String("metric", "e2e", "Latency to aggregate: e2e, ul or dl.")
+	min := fs.Float64("min", 10, "p95 latency that is green [ms].")
+	max := fs.Float64("max", 100, "p95 latency that is red [ms].")
+	out := fs.String("o", "coverage", "Name of the output files, without extension.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "coverage: %v\n", err)
+		return 1
+	}
+	if _, ok := latencyMetrics[*metric]; !ok {
+		return fail(fmt.Errorf("unsupported metric \"%s\"", *metric))
+	}
+	lat0, lon0, err := parseLatLon(*origin)
+	if err != nil {
+		return fail(err)
+	}
+	var bins coverageBins = tileBins{lat0: lat0, lon0: lon0, size: *tile}
+	if len(*route) != 0 {
+		if bins, err = newRouteBins(*route, *bin, *offRoute, lat0, lon0); err != nil {
+			return fail(err)
+		}
+	}
+
+	c := NewCoverage(bins, *metric)
+	runs := 0
+	for _, target := range fs.Args() {
+		logs, err := findLogs(target)
+		if err != nil {
+			return fail(err)
+		}
+		for _, file := range logs {
+			log, err := loadLog(file)
+			if err != nil {
+				return fail(err)
+			}
+			fmt.Printf("%s: %d of %d packets binned\n", file, c.add(file, log), len(log))
+			runs++
+		}
+	}
+	if err := c.writeGrid(*out + ".csv"); err != nil {
+		return fail(err)
+	}
+	if err := c.writeHeatmap(*out+".geojson", *min, *max); err != nil {
+		return fail(err)
+	}
+	fmt.Printf("%d bins from %d runs written to %s.csv and %s.geojson\n", len(c.cells), runs, *out, *out)
+	return 0
+}
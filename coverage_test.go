@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// A packet `x` meters east of `lat0, lon0` with a latency of `e2e`.
+func positionedPacket(seq int64, lat0, lon0, x float64, e2e time.Duration) Packet {
+	p := Packet{}
+	p.Header.Seq = seq
+	p.T2, p.T3, p.T4 = int64(e2e/2), int64(e2e/2), int64(e2e)
+	p.Latitude, p.Longitude = toGeo(lat0, lon0, x, 1)
+	return p
+}
+
+func TestCoverageTiles(t *testing.T) {
+	c := NewCoverage(tileBins{lat0: 59.35, lon0: 18.07, size: 20}, "e2e")
+	run := func(latency time.Duration) []Packet {
+		return []Packet{
+			positionedPacket(0, 59.35, 18.07, 5, latency),
+			positionedPacket(1, 59.35, 18.07, 15, latency),
+			positionedPacket(3, 59.35, 18.07, 25, latency), // one lost before it
+		}
+	}
+	c.add("a", run(10*time.Millisecond))
+	c.add("b", run(30*time.Millisecond))
+
+	keys := c.keys()
+	if len(keys) != 2 || keys[0] != (coverageKey{0, 0}) || keys[1] != (coverageKey{1, 0}) {
+		t.Fatalf("tiles are %v", keys)
+	}
+	stats := c.cells[keys[0]].stats()
+	if stats[0] != 4 || stats[1] != 0 || stats[3] != 2 || !approx(stats[4], 20) || !approx(stats[7], 20) {
+		t.Errorf("first tile is %v", stats)
+	}
+	stats = c.cells[keys[1]].stats()
+	if stats[0] != 2 || stats[1] != 2 || stats[2] != 0.5 {
+		t.Errorf("second tile is %v", stats)
+	}
+
+	dir := t.TempDir()
+	if err := c.writeGrid(path.Join(dir, "coverage.csv")); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(path.Join(dir, "coverage.csv"))
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil || len(records) != 3 || records[0][0] != "tile_x" || records[2][4] != "2" {
+		t.Errorf("grid is %v (%v)", records, err)
+	}
+}
+
+func TestCoverageRoute(t *testing.T) {
+	dir := t.TempDir()
+	route := path.Join(dir, "route.csv")
+	os.WriteFile(route, []byte("x,y\n0,0\n100,0\n100,100\n"), 0644)
+	bins, err := newRouteBins(route, 50, 10, 59.35, 18.07)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		x, y float64
+		bin  int
+		ok   bool
+	}{
+		{10, 2, 0, true},
+		{60, -3, 1, true},
+		{102, 30, 2, true},
+		{100, 100, 3, true}, // the end is in the last bin
+		{50, 50, 0, false},  // off the route
+	} {
+		lat, lon := toGeo(59.35, 18.07, c.x, c.y)
+		key, ok := bins.bin(lat, lon)
+		if ok != c.ok || (ok && key.x != c.bin) {
+			t.Errorf("%v,%v is in bin %d (%v), expected %d (%v)", c.x, c.y, key.x, ok, c.bin, c.ok)
+		}
+	}
+	bins.size = 60
+	if line := bins.geometry(coverageKey{1, 0}).Coordinates.([][]float64); len(line) != 3 {
+		t.Errorf("bin 1 is %v, expected to follow the corner", line)
+	}
+}
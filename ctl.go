@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const ctlUsage = `Usage: wp3go [flags] ctl [-json] [-timeout d] [-author name] <command> [args]
+
+Commands:
+  list                          List all nodes that answer discovery
+  get <node> <attr>             Get an attribute of a node
+  set <node> <attr> <value>     Set an attribute of a node
+  pause <node>...               Pause nodes
+  unpause <node>...             Unpause nodes
+  kill <node>...                Stop nodes
+  log <node> [file]             Save the packet log of a node as CSV (default <node>.csv)
+  tail [node]...                Print packets published by nodes (default all)
+  audit <node>                  Print who changed the parameters of a node
+  handovers <node>              Print the handovers a vehicle detected
+`
+
+// Remote-control nodes from the shell. Returns the exit code.
+func ctl(nc *nats.EncodedConn, args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), ctlUsage) }
+	asJSON := fs.Bool("json", false, "Print output as JSON.")
+	timeout := fs.Duration("timeout", 1*time.Second, "How long to wait for nodes to answer discovery.")
+	author := fs.String("author", "ctl", "Author used in requests.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	client := &Node{name: *author, kind: "ctl", attr: map[string]int{}, nc: nc}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+		return 1
+	}
+	output := func(v interface{}, text string) {
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(v)
+		} else {
+			fmt.Println(text)
+		}
+	}
+
+	switch {
+	case cmd == "list" && len(args) == 0:
+		nodes, err := discover(nc, client.name, *timeout)
+		if err != nil {
+			return fail(err)
+		}
+		if *asJSON {
+			output(nodes, "")
+			return 0
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tTYPE\tVERSION\tHOST\tPAUSED\tNTP OFFSET")
+		for _, hb := range nodes {
+			offset := "-"
+			if hb.NTP.Valid {
+				offset = time.Duration(hb.NTP.Offset).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", hb.Name, hb.Type, hb.Version, hb.Host, hb.Params["paused"] != 0, offset)
+		}
+		w.Flush()
+
+	case cmd == "get" && len(args) == 2:
+		resp, err := client.remote_get(args[0], args[1])
+		if err != nil {
+			return fail(fmt.Errorf("%w to \"%s\"", err, args[0]))
+		}
+		if !resp.Success {
+			return fail(errors.New(resp.Reason))
+		}
+		output(resp, strconv.Itoa(resp.Data))
+
+	case cmd == "set" && len(args) == 3:
+		value, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fail(err)
+		}
+		resp, err := client.remote_set(args[0], args[1], value)
+		if err != nil {
+			return fail(fmt.Errorf("%w to \"%s\"", err, args[0]))
+		}
+		output(resp, "ok")
+
+	case (cmd == "pause" || cmd == "unpause" || cmd == "kill") && len(args) > 0:
+		action := map[string]func(...string) error{
+			"pause":   client.pause,
+			"unpause": client.unpause,
+			"kill":    client.kill,
+		}[cmd]
+		if err := action(args...); err != nil {
+			return fail(err)
+		}
+		output(map[string]interface{}{"success": true, "nodes": args}, "ok")
+
+	case cmd == "log" && (len(args) == 1 || len(args) == 2):
+		log, err := client.remote_get_log(args[0])
+		if err != nil {
+			return fail(fmt.Errorf("%w to \"%s\"", err, args[0]))
+		}
+		if *asJSON && len(args) == 1 {
+			output(log, "")
+			return 0
+		}
+		fileName := fmt.Sprintf("%s.csv", args[0])
+		if len(args) == 2 {
+			fileName = args[1]
+		}
+		save(log, fileName)
+		output(map[string]interface{}{"file": fileName, "packets": len(log)}, fmt.Sprintf("Saved %d packets to %s", len(log), fileName))
+
+	case cmd == "audit" && len(args) == 1:
+		entries, err := client.remote_get_audit(args[0])
+		if err != nil {
+			return fail(fmt.Errorf("%w to \"%s\"", err, args[0]))
+		}
+		if *asJSON {
+			output(entries, "")
+			return 0
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tAUTHOR\tNAME\tOLD\tNEW\tACCEPTED\tREASON")
+		for _, e := range entries {
+			stamp := time.Unix(0, e.Time).Format("2006-01-02 15:04:05.000")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%t\t%s\n", stamp, e.Author, e.Name, e.Old, e.New, e.Accepted, e.Reason)
+		}
+		w.Flush()
+
+	case cmd == "handovers" && len(args) == 1:
+		handovers, err := client.remote_get_handovers(args[0])
+		if err != nil {
+			return fail(fmt.Errorf("%w to \"%s\"", err, args[0]))
+		}
+		if *asJSON {
+			output(handovers, "")
+			return 0
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tFROM\tTO\tRSRP\tLAT\tLON")
+		for _, h := range handovers {
+			stamp := time.Unix(0, h.Stamp).Format("2006-01-02 15:04:05.000")
+			fmt.Fprintf(w, "%s\t%x/%d\t%x/%d\t%g\t%f\t%f\n", stamp, h.From.CellID, h.From.PCI, h.To.CellID, h.To.PCI, h.To.RSRP, h.Latitude, h.Longitude)
+		}
+		w.Flush()
+
+	case cmd == "tail":
+		subjects := []string{"*.data"}
+		if len(args) > 0 {
+			subjects = []string{}
+			for _, name := range args {
+				subjects = append(subjects, fmt.Sprintf("%s.data", name))
+			}
+		}
+		for _, subject := range subjects {
+			_, err := nc.Subscribe(subject, func(subj string, p *Packet) {
+				p.Data = nil
+				if *asJSON {
+					line, _ := json.Marshal(struct {
+						Subject string `json:"subject"`
+						*Packet
+					}{subj, p})
+					fmt.Println(string(line))
+				} else {
+					fmt.Printf("%s seq=%d frame_id=%s t1=%d t2=%d t3=%d t4=%d\n", subj, p.Header.Seq, p.Header.FrameID, p.T1, p.T2, p.T3, p.T4)
+				}
+			})
+			if err != nil {
+				return fail(err)
+			}
+		}
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
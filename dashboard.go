@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var enableTUIFlag = flag.Bool("tui", false, "Show a live dashboard while the coordinator runs tests")
+
+// Terminal dashboard for the coordinator, fed by heartbeats and the
+// statistics vehicles stream while a test is running.
+type Dashboard struct {
+	mu       sync.Mutex
+	out      io.Writer
+	registry *Registry
+	vehicles map[string]VehicleStats
+	state    string
+	started  time.Time
+	duration time.Duration
+	progress int
+	total    int
+	notes    []string
+	done     chan struct{}
+}
+
+func NewDashboard(n *Node) (*Dashboard, error) {
+	registry, err := watchHeartbeats(n.nc)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dashboard{
+		out:      os.Stdout,
+		registry: registry,
+		vehicles: map[string]VehicleStats{},
+		state:    "Starting",
+		done:     make(chan struct{}),
+	}
+	_, err = n.nc.Subscribe(statsSubject("*"), func(stats *VehicleStats) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.vehicles[stats.Name] = *stats
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.draw()
+			case <-d.done:
+				return
+			}
+		}
+	}()
+	return d, nil
+}
+
+// Show what the coordinator is doing, e.g. which case it is running.
+func (d *Dashboard) setState(state string, duration time.Duration, progress, total int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state = state
+	d.started = time.Now()
+	d.duration = duration
+	d.progress = progress
+	d.total = total
+}
+
+// Show a message below the dashboard, only the last few are kept.
+func (d *Dashboard) note(format string, a ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.notes = append(d.notes, fmt.Sprintf(format, a...))
+	if len(d.notes) > 5 {
+		d.notes = d.notes[1:]
+	}
+}
+
+// Stop drawing and hand the terminal back.
+func (d *Dashboard) stop() {
+	close(d.done)
+	d.draw()
+}
+
+func (d *Dashboard) draw() {
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+
+	d.mu.Lock()
+	remaining := ""
+	if d.duration > 0 {
+		left := d.duration - time.Since(d.started)
+		if left < 0 {
+			left = 0
+		}
+		remaining = fmt.Sprintf(" - %s left", left.Round(time.Second))
+	}
+	fmt.Fprintf(&b, "(%d/%d) %s%s\n\n", d.progress, d.total, d.state, remaining)
+
+	names := []string{}
+	for name := range d.vehicles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "%-12s %8s %8s %6s  %-22s %-22s %-22s %6s %9s %9s\n", "VEHICLE", "RECEIVED", "RATE", "LOSS", "UL p50/p95/p99 [ms]", "DL p50/p95/p99 [ms]", "E2E p50/p95/p99 [ms]", "MISSED", "PEAK AoI", "NTP [ms]")
+	for _, name := range names {
+		s := d.vehicles[name]
+		triple := func(v [3]float64) string { return fmt.Sprintf("%.1f/%.1f/%.1f", v[0], v[1], v[2]) }
+		fmt.Fprintf(&b, "%-12s %8d %6.1fHz %5.1f%%  %-22s %-22s %-22s %5.1f%% %6.1f ms %9.2f\n", name, s.Received, s.Rate, 100*s.Loss, triple(s.UL), triple(s.DL), triple(s.E2E), 100*s.Missed, s.PeakAoI, ms(time.Duration(s.NTP)))
+	}
+	notes := append([]string{}, d.notes...)
+	d.mu.Unlock()
+
+	fmt.Fprintf(&b, "\n%-12s %-12s %-6s %-7s %9s\n", "NODE", "TYPE", "ALIVE", "PAUSED", "NTP [ms]")
+	for _, hb := range d.registry.Nodes() {
+		ntp := "-"
+		if hb.NTP.Valid {
+			ntp = fmt.Sprintf("%.2f", ms(time.Duration(hb.NTP.Offset)))
+		}
+		fmt.Fprintf(&b, "%-12s %-12s %-6t %-7t %9s\n", hb.Name, hb.Type, d.registry.Alive(hb.Name), hb.Params["paused"] != 0, ntp)
+	}
+
+	if len(notes) > 0 {
+		b.WriteString("\n" + strings.Join(notes, "\n") + "\n")
+	}
+	io.WriteString(d.out, b.String())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Set at build time with: go build -ldflags "-X main.version=..."
+var version = "dev"
+
+var subjectPrefix = flag.String("prefix", "testbed", "Subject prefix used for heartbeats and discovery.")
+var heartbeatInterval = flag.Duration("heartbeat", 1*time.Second, "How often should nodes publish a heartbeat?")
+
+func heartbeatSubject(name string) string {
+	return fmt.Sprintf("%s.heartbeat.%s", *subjectPrefix, name)
+}
+
+func discoverSubject() string {
+	return fmt.Sprintf("%s.discover", *subjectPrefix)
+}
+
+func (n *Node) heartbeat() Heartbeat {
+	host, _ := os.Hostname()
+	hb := Heartbeat{
+		Name:    n.name,
+		Type:    n.kind,
+		Version: version,
+		Host:    host,
+		Stamp:   time.Now().UnixNano(),
+		Params:  n.attrs(),
+	}
+	if n.ntpClient != nil {
+		hb.NTP = n.ntpClient.Status()
+	}
+	return hb
+}
+
+// Make the node visible to others: answer discovery requests and publish
+// heartbeats for as long as the node is alive.
+func (n *Node) announce() {
+	n.nc.Subscribe(discoverSubject(), func(subj, reply string, _ DiscoverRequest) {
+		hb := n.heartbeat()
+		n.nc.Publish(reply, &hb)
+	})
+
+	go func() {
+		for n.isAlive() {
+			hb := n.heartbeat()
+			n.nc.Publish(heartbeatSubject(n.name), &hb)
+			time.Sleep(*heartbeatInterval)
+		}
+	}()
+}
+
+// Ask all nodes to identify themselves and collect the answers that arrive
+// within the timeout. The result is sorted by name.
+func discover(nc *nats.EncodedConn, author string, timeout time.Duration) ([]Heartbeat, error) {
+	inbox := nats.NewInbox()
+	ch := make(chan *Heartbeat, 64)
+	sub, err := nc.BindRecvChan(inbox, ch)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	err = nc.PublishRequest(discoverSubject(), inbox, &DiscoverRequest{Author: author})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Heartbeat{}
+	deadline := time.After(timeout)
+	for {
+		select {
+		case hb := <-ch:
+			nodes = append(nodes, *hb)
+		case <-deadline:
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+			return nodes, nil
+		}
+	}
+}
+
+// Return the roles in `required` that are not covered by `nodes`. A role
+// matches either the type or the name of a node, and a role listed twice
+// needs two distinct nodes.
+func missingRoles(nodes []Heartbeat, required []string) []string {
+	used := make([]bool, len(nodes))
+	missing := []string{}
+	for _, role := range required {
+		found := false
+		for i, hb := range nodes {
+			if !used[i] && (hb.Name == role || hb.Type == role) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, role)
+		}
+	}
+	return missing
+}
+
+// Block until every required role has been discovered, or return an error
+// naming the roles that are still missing once the timeout has passed.
+func waitForNodes(n *Node, required []string, timeout time.Duration) ([]Heartbeat, error) {
+	deadline := time.Now().Add(timeout)
+	reported := ""
+	for {
+		nodes, err := discover(n.nc, n.name, time.Second)
+		if err != nil {
+			return nil, err
+		}
+		missing := missingRoles(nodes, required)
+		if len(missing) == 0 {
+			return nodes, nil
+		}
+		if time.Now().After(deadline) {
+			return nodes, fmt.Errorf("missing role(s): %s", strings.Join(missing, ", "))
+		}
+		if report := strings.Join(missing, ", "); report != reported {
+			fmt.Printf("Waiting for: %s\n", report)
+			reported = report
+		}
+	}
+}
+
+// Keeps the latest heartbeat of every node, and when it arrived.
+type Registry struct {
+	mu    sync.Mutex
+	nodes map[string]Heartbeat
+	seen  map[string]time.Time
+}
+
+func watchHeartbeats(nc *nats.EncodedConn) (*Registry, error) {
+	r := &Registry{nodes: map[string]Heartbeat{}, seen: map[string]time.Time{}}
+	_, err := nc.Subscribe(heartbeatSubject("*"), func(hb *Heartbeat) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.nodes[hb.Name] = *hb
+		r.seen[hb.Name] = time.Now()
+	})
+	return r, err
+}
+
+// All nodes that have been seen, sorted by name.
+func (r *Registry) Nodes() []Heartbeat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes := []Heartbeat{}
+	for _, hb := range r.nodes {
+		nodes = append(nodes, hb)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// Whether a heartbeat from the node arrived recently, i.e. within a few
+// heartbeat intervals.
+func (r *Registry) Alive(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen, ok := r.seen[name]
+	return ok && time.Since(seen) < *heartbeatInterval*3
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+var embeddedNATS = flag.Bool("embedded-nats", false, "Start an in-process NATS server listening on -host (a random local port if -host is not set)")
+
+// Start a NATS server in this process. It listens on the address of `host`
+// if the -host flag was given, otherwise on a random port on localhost. User,
+// password and token from the NATS config are required from clients. It has
+// no certificate to serve TLS with, so the TLS settings are refused.
+func startEmbeddedNATS(host string, conf NATSConfig) (*server.Server, error) {
+	if conf.TLS || len(conf.CA) != 0 || len(conf.Cert) != 0 || len(conf.Key) != 0 {
+		return nil, fmt.Errorf("the embedded NATS server does not serve TLS, leave out -natsTLS, -natsCA, -natsCert and -natsKey or use an external server")
+	}
+	opts := &server.Options{
+		Host:            "127.0.0.1",
+		Port:            server.RANDOM_PORT,
+		NoSigs:          true,
+		Username:        conf.User,
+		Password:        conf.Password,
+		Authorization:   conf.Token,
+		NoSystemAccount: true,
+	}
+
+	hostSet := false
+	flag.Visit(func(f *flag.Flag) { hostSet = hostSet || f.Name == "host" })
+	if hostSet {
+		if !strings.Contains(host, "://") {
+			host = "nats://" + host
+		}
+		u, err := url.Parse(host)
+		if err != nil {
+			return nil, err
+		}
+		opts.Host = u.Hostname()
+		opts.Port = 4222
+		if len(u.Port()) != 0 {
+			if opts.Port, err = strconv.Atoi(u.Port()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		return nil, err
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		srv.Shutdown()
+		return nil, fmt.Errorf("embedded NATS server did not start on %s", net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)))
+	}
+	return srv, nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestEmbeddedNATSRefusesTLS(t *testing.T) {
+	for _, conf := range []NATSConfig{{TLS: true}, {CA: "ca.pem"}, {Cert: "cert.pem", Key: "key.pem"}} {
+		if srv, err := startEmbeddedNATS("", conf); err == nil {
+			srv.Shutdown()
+			t.Errorf("started with %+v", conf)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const exportUsage = `Usage: wp3go export [-format geojson|kml] [-metric e2e|ul|dl] [-min ms] [-max ms] [-segments] [-o file] <log dir or file>...
+
+Writes the packets of packet logs with a position as points, or as line
+segments between consecutive packets, coloured by latency from green (-min)
+to red (-max). Each feature has the latencies, the number of packets lost
+before it from the same sensor and the radio context. The output is written next to each log,
+e.g. <name>.geojson. For a suite directory, all cases in its manifest are
+exported, with their handovers as extra points.
+`
+
+// Latency of a packet by name, NTP corrected.
+var latencyMetrics = map[string]func(p *Packet) time.Duration{
+	"e2e": (*Packet).EndToEnd,
+	"ul":  (*Packet).Uplink,
+	"dl":  (*Packet).Downlink,
+}
+
+// Green at `min`, through yellow, to red at `max`.
+func latencyColor(latency, min, max float64) (r, g, b uint8) {
+	f := 0.0
+	if max > min {
+		f = math.Max(0, math.Min(1, (latency-min)/(max-min)))
+	}
+	if f < 0.5 {
+		return uint8(math.Round(510 * f)), 255, 0
+	}
+	return 255, uint8(math.Round(510 * (1 - f))), 0
+}
+
+func hexColor(r, g, b uint8) string {
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// A packet with a position, and how many were lost since the one before.
+type geoPacket struct {
+	Packet
+	lost int64
+}
+
+// Packets that have a position, in order of sequence number. Losses are
+// counted per vehicle and source, for logs of several.
+func geoPackets(log []Packet) []geoPacket {
+	sorted := append([]Packet{}, log...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Header.Seq < sorted[j].Header.Seq })
+	type stream struct{ vehicle, source string }
+	points := []geoPacket{}
+	lastSeq := map[stream]int64{}
+	lost := map[stream]int64{}
+	for _, p := range sorted {
+		s := stream{p.Header.FrameID, p.Source}
+		if last, ok := lastSeq[s]; ok {
+			if p.Header.Seq == last {
+				continue // a duplicate
+			}
+			lost[s] += p.Header.Seq - last - 1
+		}
+		lastSeq[s] = p.Header.Seq
+		if p.Latitude == 0 && p.Longitude == 0 {
+			continue // losses are carried to the next one with a position
+		}
+		points = append(points, geoPacket{Packet: p, lost: lost[s]})
+		lost[s] = 0
+	}
+	return points
+}
+
+// Index of the point before each one from the same vehicle, -1 if none.
+func previousPoints(points []geoPacket) []int {
+	previous := make([]int, len(points))
+	last := map[string]int{}
+	for i, p := range points {
+		previous[i] = -1
+		if j, ok := last[p.Header.FrameID]; ok {
+			previous[i] = j
+		}
+		last[p.Header.FrameID] = i
+	}
+	return previous
+}
+
+func (p geoPacket) properties(metric string) map[string]interface{} {
+	return map[string]interface{}{
+		"seq":     p.Header.Seq,
+		"vehicle": p.Header.FrameID,
+		"source":  p.Source,
+		"time":    time.Unix(0, p.T4+p.E4).UTC().Format(time.RFC3339Nano),
+		"metric":  metric,
+		"ul":      ms(p.Uplink()),
+		"dl":      ms(p.Downlink()),
+		"e2e":     ms(p.EndToEnd()),
+		"lost":    p.lost,
+		"vel":     p.V,
+		"cell_id": p.CellID,
+		"pci":     p.PCI,
+		"rsrp":    p.RSRP,
+		"rsrq":    p.RSRQ,
+		"sinr":    p.SINR,
+		"band":    p.Band,
+		"fix":     p.Fix,
+		"hdop":    p.HDOP,
+	}
+}
+
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSON struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+func pointFeature(lat, lon float64, properties map[string]interface{}) GeoJSONFeature {
+	return GeoJSONFeature{Type: "Feature", Geometry: GeoJSONGeometry{Type: "Point", Coordinates: []float64{lon, lat}}, Properties: properties}
+}
+
+func handoverProperties(h Handover) map[string]interface{} {
+	return map[string]interface{}{
+		"handover": true,
+		"time":     time.Unix(0, h.Stamp).UTC().Format(time.RFC3339Nano),
+		"from":     fmt.Sprintf("%x/%d", h.From.CellID, h.From.PCI),
+		"to":       fmt.Sprintf("%x/%d", h.To.CellID, h.To.PCI),
+	}
+}
+
+// Options of an export, from the flags of the export command.
+type exportOptions struct {
+	format   string
+	metric   string
+	min, max float64 // [ms]
+	segments bool
+}
+
+func (o exportOptions) color(p geoPacket) (uint8, uint8, uint8) {
+	return latencyColor(ms(latencyMetrics[o.metric](&p.Packet)), o.min, o.max)
+}
+
+func writeGeoJSON(w io.Writer, points []geoPacket, handovers []Handover, o exportOptions) error {
+	doc := GeoJSON{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	previous := previousPoints(points)
+	for i, p := range points {
+		properties := p.properties(o.metric)
+		color := hexColor(o.color(p))
+		if !o.segments {
+			properties["marker-color"] = color
+			doc.Features = append(doc.Features, pointFeature(p.Latitude, p.Longitude, properties))
+			continue
+		}
+		if previous[i] < 0 {
+			continue
+		}
+		prev := points[previous[i]]
+		properties["stroke"] = color
+		properties["stroke-width"] = 4
+		doc.Features = append(doc.Features, GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   GeoJSONGeometry{Type: "LineString", Coordinates: [][]float64{{prev.Longitude, prev.Latitude}, {p.Longitude, p.Latitude}}},
+			Properties: properties,
+		})
+	}
+	for _, h := range handovers {
+		if h.Latitude != 0 || h.Longitude != 0 {
+			properties := handoverProperties(h)
+			properties["marker-symbol"] = "cell"
+			doc.Features = append(doc.Features, pointFeature(h.Latitude, h.Longitude, properties))
+		}
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(doc)
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Name        string    `xml:"name,omitempty"`
+	StyleURL    string    `xml:"styleUrl,omitempty"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Point       string    `xml:"Point>coordinates,omitempty"`
+	LineString  string    `xml:"LineString>coordinates,omitempty"`
+	Description string    `xml:"description,omitempty"`
+}
+
+type kmlIconStyle struct {
+	Color string `xml:"color,omitempty"`
+	Scale string `xml:"scale"`
+}
+
+type kmlLineStyle struct {
+	Color string `xml:"color"`
+	Width int    `xml:"width"`
+}
+
+type kmlStyle struct {
+	ID   string        `xml:"id,attr"`
+	Icon *kmlIconStyle `xml:"IconStyle,omitempty"`
+	Line *kmlLineStyle `xml:"LineStyle,omitempty"`
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Namespace  string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Styles     []kmlStyle     `xml:"Document>Style"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// KML colours are aabbggrr. Latencies share one style per tenth of the
+// colour scale.
+const kmlColorSteps = 10
+
+func kmlStyles(segments bool) []kmlStyle {
+	styles := []kmlStyle{}
+	for i := 0; i <= kmlColorSteps; i++ {
+		r, g, b := latencyColor(float64(i), 0, kmlColorSteps)
+		color := fmt.Sprintf("ff%02x%02x%02x", b, g, r)
+		style := kmlStyle{ID: fmt.Sprintf("latency%d", i)}
+		if segments {
+			style.Line = &kmlLineStyle{Color: color, Width: 4}
+		} else {
+			style.Icon = &kmlIconStyle{Color: color, Scale: "0.5"}
+		}
+		styles = append(styles, style)
+	}
+	return append(styles, kmlStyle{ID: "handover", Icon: &kmlIconStyle{Scale: "1.2"}})
+}
+
+func writeKML(w io.Writer, name string, points []geoPacket, handovers []Handover, o exportOptions) error {
+	doc := kmlDocument{Namespace: "http://www.opengis.net/kml/2.2", Name: name, Styles: kmlStyles(o.segments)}
+	previous := previousPoints(points)
+	for i, p := range points {
+		latency := ms(latencyMetrics[o.metric](&p.Packet))
+		step := 0
+		if o.max > o.min {
+			step = int(math.Round(math.Max(0, math.Min(1, (latency-o.min)/(o.max-o.min))) * kmlColorSteps))
+		}
+		placemark := kmlPlacemark{
+			Name:     fmt.Sprintf("%d", p.Header.Seq),
+			StyleURL: fmt.Sprintf("#latency%d", step),
+		}
+		properties := p.properties(o.metric)
+		keys := make([]string, 0, len(properties))
+		for k := range properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			placemark.Data = append(placemark.Data, kmlData{Name: k, Value: fmt.Sprint(properties[k])})
+		}
+		if !o.segments {
+			placemark.Point = fmt.Sprintf("%f,%f", p.Longitude, p.Latitude)
+		} else if previous[i] >= 0 {
+			prev := points[previous[i]]
+			placemark.LineString = fmt.Sprintf("%f,%f %f,%f", prev.Longitude, prev.Latitude, p.Longitude, p.Latitude)
+		} else {
+			continue
+		}
+		doc.Placemarks = append(doc.Placemarks, placemark)
+	}
+	for _, h := range handovers {
+		if h.Latitude != 0 || h.Longitude != 0 {
+			properties := handoverProperties(h)
+			doc.Placemarks = append(doc.Placemarks, kmlPlacemark{
+				Name:        "Handover",
+				StyleURL:    "#handover",
+				Description: fmt.Sprintf("%s to %s at %s", properties["from"], properties["to"], properties["time"]),
+				Point:       fmt.Sprintf("%f,%f", h.Longitude, h.Latitude),
+			})
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	return enc.Encode(doc)
+}
+
+// e.g. logs/x/y__TC1000.csv.gz -> logs/x/y__TC1000.kml
+func exportFileName(filename, format string) string {
+	ext := "." + logFormat(filename)
+	if !strings.HasSuffix(filename, ext) {
+		ext = path.Ext(filename)
+	}
+	return strings.TrimSuffix(filename, ext) + "." + format
+}
+
+func exportLog(file, out string, handovers []Handover, o exportOptions) error {
+	log, err := loadLog(file)
+	if err != nil {
+		return err
+	}
+	points := geoPackets(log)
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if o.format == "kml" {
+		err = writeKML(f, path.Base(file), points, handovers, o)
+	} else {
+		err = writeGeoJSON(f, points, handovers, o)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Printf("%s: %d of %d packets have a position\n", out, len(points), len(log))
+	return f.Close()
+}
+
+// The packet log of a case, with the imported radio context if there is one.
+func caseLog(dir string, result CaseResult) string {
+	if len(result.RadioFile) != 0 {
+		return path.Join(dir, result.RadioFile)
+	}
+	return path.Join(dir, result.Filename)
+}
+
+// Export packet logs as GeoJSON or KML. Returns the exit code.
+func export(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), exportUsage) }
+	var o exportOptions
+	fs.StringVar(&o.format, "format", "geojson", "Output format: geojson or kml.")
+	fs.StringVar(&o.metric, "metric", "e2e", "Latency that sets the colour: e2e, ul or dl.")
+	fs.Float64Var(&o.min, "min", 10, "Latency that is green [ms].")
+	fs.Float64Var(&o.max, "max", 100, "Latency that is red [ms].")
+	fs.BoolVar(&o.segments, "segments", false, "Write line segments between packets instead of points.")
+	out := fs.String("o", "", "Output file, if a single log file is exported.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (len(*out) != 0 && fs.NArg() != 1) {
+		fs.Usage()
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	if o.format != "geojson" && o.format != "kml" {
+		return fail(fmt.Errorf("unsupported format \"%s\"", o.format))
+	}
+	if _, ok := latencyMetrics[o.metric]; !ok {
+		return fail(fmt.Errorf("unsupported metric \"%s\"", o.metric))
+	}
+
+	for _, target := range fs.Args() {
+		info, err := os.Stat(target)
+		if err != nil {
+			return fail(err)
+		}
+		if !info.IsDir() {
+			file := exportFileName(target, o.format)
+			if len(*out) != 0 {
+				file = *out
+			}
+			if err := exportLog(target, file, nil, o); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		manifest, err := loadManifest(target)
+		if err != nil {
+			return fail(err)
+		}
+		for _, result := range manifest.Cases {
+			if err := exportLog(caseLog(target, result), exportFileName(path.Join(target, result.Filename), o.format), result.Handovers, o); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	return 0
+}
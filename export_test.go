@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestLatencyColor(t *testing.T) {
+	for _, c := range []struct {
+		latency float64
+		want    string
+	}{
+		{0, "#00ff00"},
+		{10, "#00ff00"},
+		{55, "#ffff00"},
+		{100, "#ff0000"},
+		{500, "#ff0000"},
+	} {
+		if got := hexColor(latencyColor(c.latency, 10, 100)); got != c.want {
+			t.Errorf("%v ms is %s, expected %s", c.latency, got, c.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	packet := func(seq int64, lat, lon float64, e2e time.Duration) Packet {
+		p := Packet{}
+		p.Header.Seq = seq
+		p.T1, p.T2, p.T3, p.T4 = 0, int64(e2e/2), int64(e2e/2), int64(e2e)
+		p.Latitude, p.Longitude = lat, lon
+		return p
+	}
+	log := []Packet{
+		packet(0, 59.35, 18.07, 10*time.Millisecond),
+		packet(1, 0, 0, 10*time.Millisecond), // no fix
+		packet(1, 0, 0, 10*time.Millisecond), // a duplicate
+		packet(4, 59.36, 18.07, 100*time.Millisecond),
+	}
+	points := geoPackets(log)
+	if len(points) != 2 || points[1].lost != 2 {
+		t.Fatalf("points are %+v", points)
+	}
+
+	// Two sensors number their packets alike, each losing one
+	from := func(source string, seq int64) Packet {
+		p := packet(seq, 59.35, 18.07, 10*time.Millisecond)
+		p.Source = source
+		return p
+	}
+	sensors := geoPackets([]Packet{from("sensor1", 0), from("sensor2", 0), from("sensor2", 1), from("sensor1", 2), from("sensor2", 3)})
+	if len(sensors) != 5 || sensors[3].lost != 1 || sensors[4].lost != 1 {
+		t.Errorf("points of two sensors are %+v", sensors)
+	}
+
+	o := exportOptions{format: "geojson", metric: "e2e", min: 10, max: 100}
+	var buf bytes.Buffer
+	handovers := []Handover{{Latitude: 59.355, Longitude: 18.07}}
+	if err := writeGeoJSON(&buf, points, handovers, o); err != nil {
+		t.Fatal(err)
+	}
+	var doc GeoJSON
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Features) != 3 || doc.Features[1].Properties["marker-color"] != "#ff0000" || doc.Features[2].Properties["handover"] != true {
+		t.Errorf("GeoJSON is %s", buf.String())
+	}
+
+	o.segments = true
+	buf.Reset()
+	if err := writeKML(&buf, "test", points, nil, o); err != nil {
+		t.Fatal(err)
+	}
+	var kml kmlDocument
+	if err := xml.Unmarshal(buf.Bytes(), &kml); err != nil {
+		t.Fatal(err)
+	}
+	if len(kml.Placemarks) != 1 || kml.Placemarks[0].StyleURL != "#latency10" || kml.Placemarks[0].LineString != "18.070000,59.350000 18.070000,59.360000" {
+		t.Errorf("KML is %s", buf.String())
+	}
+
+	if name := exportFileName("logs/x/y__TC1000.csv.gz", "kml"); name != "logs/x/y__TC1000.kml" {
+		t.Errorf("export file is %s", name)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var deadlineFlag = flag.Duration("deadline", 100*time.Millisecond, "How soon after it is sent must a packet arrive? Attached by sensors and RSUs to every packet (0 for none)")
+
+// Attach the node's DEADLINE [ms] to a packet sent at T1, as the NTP
+// corrected time it must arrive by.
+func (n *Node) stampDeadline(p *Packet) {
+	if deadline, ok := n.getAttr("DEADLINE"); ok && deadline > 0 {
+		p.Deadline = p.T1 + p.E1 + int64(deadline)*int64(time.Millisecond)
+	}
+}
+
+// Whether a packet with a deadline arrived after it.
+func (p *Packet) late() bool {
+	return p.Deadline != 0 && p.T4+p.E4 > p.Deadline
+}
+
+// Fraction of the packets in the sequence number range of each source that
+// did not arrive by their deadline, lost ones included, and of the received
+// ones that arrived late.
+func deadlineMisses(packets []Packet) (float64, float64) {
+	onTime, received, late := 0, 0, 0
+	sent := int64(0)
+	for _, group := range bySource(packets) {
+		seqs := map[int64]bool{}
+		minSeq, maxSeq := int64(0), int64(-1)
+		for _, p := range group {
+			if p.Deadline == 0 {
+				continue
+			}
+			if maxSeq < minSeq {
+				minSeq, maxSeq = p.Header.Seq, p.Header.Seq
+			}
+			if p.Header.Seq < minSeq {
+				minSeq = p.Header.Seq
+			}
+			if p.Header.Seq > maxSeq {
+				maxSeq = p.Header.Seq
+			}
+			if seqs[p.Header.Seq] {
+				continue // a duplicate
+			}
+			seqs[p.Header.Seq] = true
+			if p.late() {
+				late++
+			} else {
+				onTime++
+			}
+		}
+		received += len(seqs)
+		sent += maxSeq - minSeq + 1
+	}
+	if received == 0 {
+		return 0, 0
+	}
+	return 1 - float64(onTime)/float64(sent), float64(late) / float64(received)
+}
+
+// An update of what the vehicle knows: the freshest packet so far arrived.
+// The age of information grows by one ms per ms between updates, and drops
+// at each from `peak` to `age`.
+type aoiUpdate struct {
+	arrival int64   // NTP corrected [ns]
+	peak    float64 // [ms], 0 for the first update
+	age     float64 // [ms]
+}
+
+// Age of information of the packets received: its updates, time average
+// and highest peak [ms].
+func ageOfInformation(packets []Packet) ([]aoiUpdate, float64, float64) {
+	arrivals := make([]Packet, len(packets))
+	copy(arrivals, packets)
+	sort.SliceStable(arrivals, func(i, j int) bool { return arrivals[i].T4+arrivals[i].E4 < arrivals[j].T4+arrivals[j].E4 })
+
+	updates := []aoiUpdate{}
+	var freshest int64 // of the packets received so far, when it was sent
+	area, peak := 0.0, 0.0
+	for _, p := range arrivals {
+		sent, arrival := p.T1+p.E1, p.T4+p.E4
+		if len(updates) != 0 && sent <= freshest {
+			continue // older than what the vehicle already has
+		}
+		update := aoiUpdate{arrival: arrival, age: ms(time.Duration(arrival - sent))}
+		if len(updates) != 0 {
+			last := updates[len(updates)-1]
+			update.peak = ms(time.Duration(arrival - freshest))
+			area += (last.age + update.peak) / 2 * ms(time.Duration(arrival-last.arrival))
+			if update.peak > peak {
+				peak = update.peak
+			}
+		}
+		updates = append(updates, update)
+		freshest = sent
+	}
+	if len(updates) < 2 {
+		return updates, 0, peak
+	}
+	return updates, area / ms(time.Duration(updates[len(updates)-1].arrival-updates[0].arrival)), peak
+}
+
+// Mean age of information over the sources of the packets, and the highest
+// peak of any [ms]. Each source is information of its own, a sensor being
+// no fresher for another having sent.
+func sourceAoI(packets []Packet) (float64, float64) {
+	groups := bySource(packets)
+	mean, peak := 0.0, 0.0
+	for _, group := range groups {
+		_, m, p := ageOfInformation(group)
+		mean += m / float64(len(groups))
+		peak = math.Max(peak, p)
+	}
+	return mean, peak
+}
+
+// Write the age of information of each vehicle and source over a case, at
+// every update.
+func writeAoI(filename string, vehicles []string, logs map[string][]Packet) error {
+	rows := [][]string{}
+	for _, vehicle := range vehicles {
+		for _, group := range bySource(logs[vehicle]) {
+			updates, _, _ := ageOfInformation(group)
+			for _, u := range updates {
+				rows = append(rows, []string{
+					vehicle,
+					group[0].Source,
+					strconv.FormatInt(u.arrival, 10),
+					strconv.FormatFloat(u.peak, 'f', -1, 64),
+					strconv.FormatFloat(u.age, 'f', -1, 64),
+				})
+			}
+		}
+	}
+	return writeCSV(filename, []string{"vehicle", "source", "t", "peak", "age"}, rows)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlineMisses(t *testing.T) {
+	received := func(seq int64, latency time.Duration) Packet {
+		p := Packet{T1: seq * int64(100*time.Millisecond)}
+		p.Header.Seq = seq
+		p.T4 = p.T1 + int64(latency)
+		p.Deadline = p.T1 + int64(50*time.Millisecond)
+		return p
+	}
+	log := []Packet{
+		received(0, 10*time.Millisecond),
+		received(1, 60*time.Millisecond), // late
+		// 2 is lost
+		received(3, 50*time.Millisecond),
+		received(3, 70*time.Millisecond), // a late duplicate
+	}
+	missed, late := deadlineMisses(log)
+	if !approx(missed, 0.5) || !approx(late, 1.0/3) {
+		t.Errorf("missed %v, late %v", missed, late)
+	}
+	if missed, late := deadlineMisses([]Packet{{T4: 1}}); missed != 0 || late != 0 {
+		t.Errorf("packets without deadlines missed %v, late %v", missed, late)
+	}
+
+	node := &Node{attr: map[string]int{"DEADLINE": 20}}
+	p := Packet{T1: 1000, E1: 5}
+	node.stampDeadline(&p)
+	if p.Deadline != 1005+int64(20*time.Millisecond) {
+		t.Errorf("deadline is %d", p.Deadline)
+	}
+}
+
+func TestAgeOfInformation(t *testing.T) {
+	packet := func(sent, arrived int) Packet {
+		return Packet{T1: int64(sent) * int64(time.Millisecond), T4: int64(arrived) * int64(time.Millisecond)}
+	}
+	log := []Packet{
+		packet(0, 10),
+		packet(200, 220), // arrived before the one sent at 100
+		packet(100, 250), // stale, no update
+		packet(300, 340),
+	}
+	updates, mean, peak := ageOfInformation(log)
+	if len(updates) != 3 {
+		t.Fatalf("%d updates", len(updates))
+	}
+	if updates[1].peak != 220 || updates[1].age != 20 || updates[2].peak != 140 || updates[2].age != 40 {
+		t.Errorf("updates are %+v", updates)
+	}
+	// From 10 ms to 340 ms the age goes 10 to 220, then 20 to 140
+	want := ((10+220)/2.0*210 + (20+140)/2.0*120) / 330
+	if !approx(mean, want) || peak != 220 {
+		t.Errorf("mean %v, peak %v", mean, peak)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/goroslib/v2/pkg/msgs/sensor_msgs"
+)
+
+var gnssFlag = flag.String("gnss", "", "NMEA source of the vehicle's position: a serial device or file, tcp:host:port, or gpsd:host:port")
+var gnssTimeFlag = flag.Bool("gnssTime", false, "Record the offset of the vehicle clock to GNSS time in each packet")
+
+// The latest position from NMEA sentences.
+type GNSSFix struct {
+	Time      time.Time // UTC, date only known from RMC
+	Latitude  float64
+	Longitude float64
+	Altitude  float64 // [m]
+	Speed     float64 // [m/s]
+	Course    float64 // [deg] clockwise from north
+	Quality   int     // GGA fix quality, 0 is none
+	HDOP      float64
+}
+
+// Parse "ddmm.mmmm" and a hemisphere into degrees.
+func nmeaDegrees(value, hemisphere string) (float64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	deg := math.Floor(v / 100)
+	deg += (v - deg*100) / 60
+	if hemisphere == "S" || hemisphere == "W" {
+		deg = -deg
+	}
+	return deg, nil
+}
+
+// Parse "hhmmss.ss" on the date of `day`.
+func nmeaTime(value string, day time.Time) (time.Time, error) {
+	if len(value) < 6 {
+		return time.Time{}, fmt.Errorf("bad time \"%s\"", value)
+	}
+	h, err1 := strconv.Atoi(value[0:2])
+	m, err2 := strconv.Atoi(value[2:4])
+	s, err3 := strconv.ParseFloat(value[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return time.Time{}, fmt.Errorf("bad time \"%s\"", value)
+	}
+	y, mo, d := day.Date()
+	return time.Date(y, mo, d, h, m, 0, int(s*1e9), time.UTC), nil
+}
+
+// Update the fix with a sentence, returns whether it was a GGA or RMC that
+// completes a position. Other sentences are ignored.
+func parseNMEA(line string, fix *GNSSFix) (bool, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return false, nil
+	}
+	body, sum, ok := strings.Cut(line[1:], "*")
+	if ok {
+		want, err := strconv.ParseUint(sum, 16, 8)
+		if err != nil {
+			return false, fmt.Errorf("bad checksum in %s", line)
+		}
+		var got byte
+		for i := 0; i < len(body); i++ {
+			got ^= body[i]
+		}
+		if uint64(got) != want {
+			return false, fmt.Errorf("checksum mismatch in %s", line)
+		}
+	}
+	fields := strings.Split(body, ",")
+	if len(fields[0]) < 5 {
+		return false, nil
+	}
+	float := func(i int) float64 {
+		if i >= len(fields) {
+			return 0
+		}
+		v, _ := strconv.ParseFloat(fields[i], 64)
+		return v
+	}
+	var err error
+	switch fields[0][2:] { // any talker, e.g. GP, GN
+	case "GGA":
+		// GGA,time,lat,N,lon,E,quality,satellites,hdop,altitude,M,...
+		if len(fields) < 10 {
+			return false, fmt.Errorf("short GGA: %s", line)
+		}
+		fix.Quality, _ = strconv.Atoi(fields[6])
+		fix.HDOP = float(8)
+		fix.Altitude = float(9)
+		if fix.Quality == 0 {
+			return true, nil
+		}
+		if fix.Latitude, err = nmeaDegrees(fields[2], fields[3]); err != nil {
+			return false, err
+		}
+		if fix.Longitude, err = nmeaDegrees(fields[4], fields[5]); err != nil {
+			return false, err
+		}
+		if t, err := nmeaTime(fields[1], fix.Time); err == nil {
+			fix.Time = t // on the date of the last RMC, if any
+		}
+		return true, nil
+	case "RMC":
+		// RMC,time,status,lat,N,lon,E,speed [kn],course,date ddmmyy,...
+		if len(fields) < 10 {
+			return false, fmt.Errorf("short RMC: %s", line)
+		}
+		if fields[2] != "A" {
+			fix.Quality = 0
+			return true, nil
+		}
+		if fix.Latitude, err = nmeaDegrees(fields[3], fields[4]); err != nil {
+			return false, err
+		}
+		if fix.Longitude, err = nmeaDegrees(fields[5], fields[6]); err != nil {
+			return false, err
+		}
+		fix.Speed = float(7) * 1852 / 3600
+		fix.Course = float(8)
+		if date, err := time.Parse("020106", fields[9]); err == nil {
+			if t, err := nmeaTime(fields[1], date); err == nil {
+				fix.Time = t
+			}
+		}
+		if fix.Quality == 0 {
+			fix.Quality = 1 // until a GGA says otherwise
+		}
+		return true, nil
+	case "VTG":
+		// VTG,course,T,course,M,speed,N,speed [km/h],K,...
+		if len(fields) >= 8 {
+			fix.Course = float(1)
+			fix.Speed = float(7) / 3.6
+		}
+	}
+	return false, nil
+}
+
+// ROS fix status for a GGA fix quality.
+func navSatStatus(quality int) int8 {
+	switch quality {
+	case 0:
+		return sensor_msgs.NavSatStatus_STATUS_NO_FIX
+	case 2:
+		return sensor_msgs.NavSatStatus_STATUS_SBAS_FIX
+	case 4, 5:
+		return sensor_msgs.NavSatStatus_STATUS_GBAS_FIX
+	default:
+		return sensor_msgs.NavSatStatus_STATUS_FIX
+	}
+}
+
+// Open the -gnss source, returns whether it is a file to replay in real time.
+func openGNSS(spec string) (io.ReadCloser, bool, error) {
+	if addr, ok := strings.CutPrefix(spec, "tcp:"); ok {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		return conn, false, err
+	}
+	if addr, ok := strings.CutPrefix(spec, "gpsd:"); ok {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			return nil, false, err
+		}
+		// gpsd sends JSON reports too, they are skipped as non-NMEA lines
+		if _, err := conn.Write([]byte(`?WATCH={"enable":true,"nmea":true}` + "\n")); err != nil {
+			conn.Close()
+			return nil, false, err
+		}
+		return conn, false, nil
+	}
+	file, err := os.Open(spec)
+	if err != nil {
+		return nil, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return file, info.Mode().IsRegular(), nil
+}
+
+// Offset of the local clock to GNSS time, from when sentences arrive. They
+// arrive after the time they carry, so the largest recent offset is used.
+type gnssClock struct {
+	samples []int64
+	last    time.Time
+}
+
+func (c *gnssClock) add(gnss time.Time, arrived time.Time) {
+	if gnss.Equal(c.last) {
+		return // same epoch, a later sentence
+	}
+	c.last = gnss
+	c.samples = append(c.samples, gnss.Sub(arrived).Nanoseconds())
+	if len(c.samples) > 16 {
+		c.samples = c.samples[1:]
+	}
+}
+
+func (c *gnssClock) offset() int64 {
+	if len(c.samples) == 0 {
+		return 0
+	}
+	max := c.samples[0]
+	for _, s := range c.samples[1:] {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// Read NMEA from `r` into the feed until it ends or the node dies. Files are
+// paced by the times in their sentences.
+func (n *Node) readGNSS(r io.Reader, paced bool, feed *VehicleFeed, clock *gnssClock) error {
+	scanner := bufio.NewScanner(r)
+	var fix GNSSFix
+	var lat0, lon0 float64
+	var lastTime time.Time
+	for n.isAlive() && scanner.Scan() {
+		complete, err := parseNMEA(scanner.Text(), &fix)
+		if err != nil {
+			fmt.Printf("GNSS error: %v\n", err)
+			continue
+		}
+		if !complete {
+			continue
+		}
+		now := time.Now()
+		if paced && !lastTime.IsZero() && fix.Time.After(lastTime) {
+			wait := fix.Time.Sub(lastTime)
+			if wait > 5*time.Second {
+				wait = 5 * time.Second // a gap in the recording
+			}
+			time.Sleep(wait)
+			now = time.Now()
+		}
+		if !fix.Time.IsZero() {
+			lastTime = fix.Time
+			if !paced && fix.Time.Year() > 1 {
+				clock.add(fix.Time, now)
+			}
+		}
+		if fix.Quality == 0 {
+			feed.setGNSS(GNSSContext{Fix: 0, HDOP: fix.HDOP})
+			continue
+		}
+
+		gps := sensor_msgs.NavSatFix{Latitude: fix.Latitude, Longitude: fix.Longitude, Altitude: fix.Altitude}
+		gps.Header.Stamp = now
+		gps.Status.Status = navSatStatus(fix.Quality)
+		gps.Status.Service = sensor_msgs.NavSatStatus_SERVICE_GPS
+		feed.setGPS(gps)
+
+		if lat0 == 0 && lon0 == 0 {
+			lat0, lon0 = fix.Latitude, fix.Longitude
+		}
+		state := VehicleState{V: float32(fix.Speed), Yaw: math.Remainder((90-fix.Course)*math.Pi/180, 2*math.Pi)}
+		state.Header.Stamp = now
+		state.X, state.Y = toLocal(lat0, lon0, fix.Latitude, fix.Longitude)
+		feed.setState(state)
+
+		gnss := GNSSContext{Fix: fix.Quality, HDOP: fix.HDOP}
+		if *gnssTimeFlag {
+			gnss.GNSSOffset = clock.offset()
+		}
+		feed.setGNSS(gnss)
+	}
+	return scanner.Err()
+}
+
+// Keep reading the -gnss source, reconnecting to sockets and devices if
+// they fail. A file is read once.
+func (n *Node) watchGNSS(spec string, feed *VehicleFeed) {
+	clock := &gnssClock{}
+	for n.isAlive() {
+		r, paced, err := openGNSS(spec)
+		if err != nil {
+			fmt.Printf("GNSS error: %v\n", err)
+		} else {
+			err = n.readGNSS(r, paced, feed, clock)
+			r.Close()
+			if paced {
+				return
+			}
+			if err != nil {
+				fmt.Printf("GNSS error: %v\n", err)
+			}
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseNMEA(t *testing.T) {
+	var fix GNSSFix
+	complete, err := parseNMEA("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", &fix)
+	if err != nil || !complete {
+		t.Fatalf("GGA: %v", err)
+	}
+	if !approx(fix.Latitude, 48.1173) || !approx(fix.Longitude, 11.516666666666667) || fix.Quality != 1 || fix.HDOP != 0.9 || fix.Altitude != 545.4 {
+		t.Errorf("GGA gave %+v", fix)
+	}
+
+	complete, err = parseNMEA("$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A", &fix)
+	if err != nil || !complete {
+		t.Fatalf("RMC: %v", err)
+	}
+	if math.Abs(fix.Speed-11.52) > 0.01 || fix.Course != 84.4 || !fix.Time.Equal(time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)) {
+		t.Errorf("RMC gave %+v", fix)
+	}
+
+	if _, err := parseNMEA("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48", &fix); err == nil {
+		t.Error("no error for a bad checksum")
+	}
+	if complete, _ := parseNMEA(`{"class":"VERSION"}`, &fix); complete {
+		t.Error("gpsd JSON taken as a fix")
+	}
+}
+
+// NMEA with a checksum.
+func sentence(body string) string {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X\r\n", body, sum)
+}
+
+// Stand-in for a receiver streaming NMEA over TCP, like gpsd or a phone app.
+func startNMEAServer(t *testing.T, sentences []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for _, s := range sentences {
+					if _, err := conn.Write([]byte(s)); err != nil {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestGNSSOverTCP(t *testing.T) {
+	now := time.Now().UTC()
+	stamp := now.Format("150405.00")
+	date := now.Format("020106")
+	addr := startNMEAServer(t, []string{
+		sentence("GNGGA," + stamp + ",5921.000,N,01804.200,E,4,12,0.7,30.0,M,,M,,"),
+		sentence("GNRMC," + stamp + ",A,5921.000,N,01804.200,E,19.438,90.0," + date + ",,,A"),
+	})
+
+	node := &Node{name: "vehicle", attr: map[string]int{"alive": 1}}
+	feed := &VehicleFeed{}
+	setFlags(t, map[string]string{"gnssTime": "true"})
+	r, paced, err := openGNSS("tcp:" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paced {
+		t.Error("socket is paced like a file")
+	}
+	defer r.Close()
+	if err := node.readGNSS(r, paced, feed, &gnssClock{}); err != nil {
+		t.Fatal(err)
+	}
+
+	state, gps := feed.get()
+	gnss := feed.getGNSS()
+	if !approx(gps.Latitude, 59.35) || !approx(gps.Longitude, 18.07) || gps.Status.Status != 2 {
+		t.Errorf("fix is %v,%v with status %d", gps.Latitude, gps.Longitude, gps.Status.Status)
+	}
+	if math.Abs(float64(state.V)-10) > 0.01 || !approx(state.Yaw, 0) {
+		t.Errorf("moving at %v m/s heading %v", state.V, state.Yaw)
+	}
+	if gnss.Fix != 4 || gnss.HDOP != 0.7 {
+		t.Errorf("fix quality is %+v", gnss)
+	}
+	// The sentence only has centiseconds, and arrives after its time
+	if offset := time.Duration(gnss.GNSSOffset); offset > 10*time.Millisecond || offset < -time.Second {
+		t.Errorf("GNSS offset is %v", offset)
+	}
+}
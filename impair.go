@@ -0,0 +1,365 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var netemDevFlag = flag.String("netem", "", "Apply impairments with Linux tc netem on this interface instead of in software, e.g. eth0")
+var impairFlag = flag.String("impair", "", "Impairments per case, separated by ; (maps to cases), e.g. \"delay=20ms,jitter=5ms,loss=1%;ul.rate=5000\"")
+
+// Attributes that configure the impairment of a node's outgoing packets.
+// Probabilities are in units of 0.01 % (10000 is always).
+var impairAttrs = []string{
+	"IMPAIR_DELAY",     // [ms]
+	"IMPAIR_JITTER",    // [ms]
+	"IMPAIR_DIST",      // distribution of the jitter, see impairDists
+	"IMPAIR_LOSS",      // probability of Bernoulli loss
+	"IMPAIR_GE_P",      // Gilbert-Elliott probability good -> bad
+	"IMPAIR_GE_R",      // Gilbert-Elliott probability bad -> good
+	"IMPAIR_GE_BAD",    // loss probability in the bad state
+	"IMPAIR_GE_GOOD",   // loss probability in the good state
+	"IMPAIR_RATE",      // bandwidth cap [kbit/s]
+	"IMPAIR_REORDER",   // probability a packet skips the delay
+	"IMPAIR_DUPLICATE", // probability a packet is sent twice
+}
+
+var impairDists = []string{"uniform", "normal", "pareto"}
+
+type ImpairConfig struct {
+	Delay     time.Duration
+	Jitter    time.Duration
+	Dist      int
+	Loss      float64
+	GEP       float64
+	GER       float64
+	GEBad     float64
+	GEGood    float64
+	Rate      int
+	Reorder   float64
+	Duplicate float64
+}
+
+func impairConfig(n *Node) ImpairConfig {
+	get := func(name string) int {
+		val, _ := n.getAttr(name)
+		return val
+	}
+	prob := func(name string) float64 {
+		return float64(get(name)) / 10000
+	}
+	return ImpairConfig{
+		Delay:     time.Duration(get("IMPAIR_DELAY")) * time.Millisecond,
+		Jitter:    time.Duration(get("IMPAIR_JITTER")) * time.Millisecond,
+		Dist:      get("IMPAIR_DIST"),
+		Loss:      prob("IMPAIR_LOSS"),
+		GEP:       prob("IMPAIR_GE_P"),
+		GER:       prob("IMPAIR_GE_R"),
+		GEBad:     prob("IMPAIR_GE_BAD"),
+		GEGood:    prob("IMPAIR_GE_GOOD"),
+		Rate:      get("IMPAIR_RATE"),
+		Reorder:   prob("IMPAIR_REORDER"),
+		Duplicate: prob("IMPAIR_DUPLICATE"),
+	}
+}
+
+func (c ImpairConfig) enabled() bool {
+	return c != ImpairConfig{}
+}
+
+// Decides what happens to a packet of `size` bytes on an emulated link: the
+// delays after which copies of it are delivered, none if it is lost.
+type Impairment interface {
+	Delays(p *Packet, size int, now time.Time) []time.Duration
+}
+
+// Software emulation of the impairments tc netem offers.
+type netemModel struct {
+	config    ImpairConfig
+	rng       *rand.Rand
+	bad       bool
+	busyUntil time.Time
+}
+
+func (m *netemModel) chance(p float64) bool {
+	return p > 0 && m.rng.Float64() < p
+}
+
+func (m *netemModel) jitter() time.Duration {
+	j := float64(m.config.Jitter)
+	switch m.config.Dist {
+	case 1: // normal
+		return time.Duration(m.rng.NormFloat64() * j)
+	case 2: // pareto with shape 3, scaled to the same standard deviation
+		const alpha = 3.0
+		mean := alpha / (alpha - 1)
+		std := math.Sqrt(alpha / ((alpha - 1) * (alpha - 1) * (alpha - 2)))
+		x := 1 / math.Pow(1-m.rng.Float64(), 1/alpha)
+		return time.Duration((x - mean) / std * j)
+	default: // uniform
+		return time.Duration((2*m.rng.Float64() - 1) * j)
+	}
+}
+
+func (m *netemModel) Delays(p *Packet, size int, now time.Time) []time.Duration {
+	c := m.config
+
+	// Gilbert-Elliott state transition, then loss in the current state
+	if c.GEP > 0 || c.GER > 0 {
+		if m.bad && m.chance(c.GER) {
+			m.bad = false
+		} else if !m.bad && m.chance(c.GEP) {
+			m.bad = true
+		}
+		if (m.bad && m.chance(c.GEBad)) || (!m.bad && m.chance(c.GEGood)) {
+			return nil
+		}
+	}
+	if m.chance(c.Loss) {
+		return nil
+	}
+
+	delay := time.Duration(0)
+	if !m.chance(c.Reorder) {
+		delay = c.Delay + m.jitter()
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	// Packets queue behind each other on a rate limited link
+	if c.Rate > 0 {
+		start := now
+		if m.busyUntil.After(start) {
+			start = m.busyUntil
+		}
+		m.busyUntil = start.Add(time.Duration(float64(size*8) / float64(c.Rate*1000) * float64(time.Second)))
+		delay += m.busyUntil.Sub(now)
+	}
+
+	if m.chance(c.Duplicate) {
+		return []time.Duration{delay, delay}
+	}
+	return []time.Duration{delay}
+}
+
+// Emulated link for a node's outgoing packets, configured by its IMPAIR_*
+// attributes unless another impairment is set.
+type Link struct {
+	mu         sync.Mutex
+	node       *Node
+	netem      netemModel
+	impairment Impairment
+}
+
+func NewLink(n *Node) *Link {
+	for _, name := range impairAttrs {
+		n.setAttr(name, 0)
+	}
+	link := &Link{node: n, netem: netemModel{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}}
+	if len(*netemDevFlag) != 0 {
+		go link.applyNetem(*netemDevFlag)
+	}
+	return link
+}
+
+// Deliver the packet, or copies of it, after the link's delay, if it is not
+// lost. Without impairments it is delivered right away.
+func (l *Link) Send(p *Packet, deliver func(*Packet)) {
+	l.mu.Lock()
+	var delays []time.Duration
+	if l.impairment != nil {
+		delays = l.impairment.Delays(p, len(p.Data), time.Now())
+	} else if config := impairConfig(l.node); config.enabled() && len(*netemDevFlag) == 0 {
+		l.netem.config = config
+		delays = l.netem.Delays(p, len(p.Data), time.Now())
+	} else {
+		l.mu.Unlock()
+		deliver(p)
+		return
+	}
+	l.mu.Unlock()
+
+	for _, delay := range delays {
+		c := *p
+		if delay <= 0 {
+			deliver(&c)
+		} else {
+			time.AfterFunc(delay, func() { deliver(&c) })
+		}
+	}
+}
+
+func percent(p float64) string {
+	return strconv.FormatFloat(100*p, 'f', -1, 64) + "%"
+}
+
+// Arguments for tc that configure netem like `c`, nil if it is disabled.
+func netemArgs(dev string, c ImpairConfig) []string {
+	if !c.enabled() {
+		return nil
+	}
+	args := []string{"qdisc", "replace", "dev", dev, "root", "netem"}
+	if c.Delay > 0 || c.Jitter > 0 {
+		args = append(args, "delay", fmt.Sprintf("%dms", c.Delay.Milliseconds()))
+		if c.Jitter > 0 {
+			args = append(args, fmt.Sprintf("%dms", c.Jitter.Milliseconds()))
+			if c.Dist > 0 && c.Dist < len(impairDists) {
+				args = append(args, "distribution", impairDists[c.Dist])
+			}
+		}
+	}
+	if c.GEP > 0 || c.GER > 0 {
+		args = append(args, "loss", "gemodel", percent(c.GEP), percent(c.GER), percent(c.GEBad), percent(c.GEGood)) // 1-h and 1-k are the loss in each state
+	} else if c.Loss > 0 {
+		args = append(args, "loss", "random", percent(c.Loss))
+	}
+	if c.Duplicate > 0 {
+		args = append(args, "duplicate", percent(c.Duplicate))
+	}
+	if c.Reorder > 0 {
+		args = append(args, "reorder", percent(c.Reorder))
+	}
+	if c.Rate > 0 {
+		args = append(args, "rate", fmt.Sprintf("%dkbit", c.Rate))
+	}
+	return args
+}
+
+// Keep the netem qdisc on `dev` in sync with the node's attributes.
+func (l *Link) applyNetem(dev string) {
+	var applied ImpairConfig
+	for l.node.isAlive() {
+		config := impairConfig(l.node)
+		if !reflect.DeepEqual(config, applied) {
+			args := netemArgs(dev, config)
+			if args == nil {
+				args = []string{"qdisc", "del", "dev", dev, "root"}
+			}
+			out, err := exec.Command("tc", args...).CombinedOutput()
+			if err != nil && config.enabled() {
+				fmt.Printf("tc %s: %v %s\n", strings.Join(args, " "), err, out)
+			}
+			applied = config
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Whether a spec has keys for the downlink only, which a broadcast has none
+// of: the RSU sends to the vehicles over its uplink.
+func hasDownlinkKeys(spec string) bool {
+	for _, item := range strings.Split(spec, ",") {
+		if strings.HasPrefix(strings.TrimSpace(item), "dl.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse an impairment spec into the attributes for the sensor (ul) and the
+// server (dl). Keys without a prefix apply to both, e.g.
+//
+//	delay=20ms,jitter=5ms,dist=normal,loss=1%,ul.rate=5000,dl.ge=1%/30%/100%/0%
+func parseImpairment(spec string) (ul map[string]int, dl map[string]int, err error) {
+	ul, dl = map[string]int{}, map[string]int{}
+	for _, name := range impairAttrs {
+		ul[name] = 0
+		dl[name] = 0
+	}
+	prob := func(s string) (int, error) {
+		scale := 10000.0
+		if strings.HasSuffix(s, "%") {
+			s = strings.TrimSuffix(s, "%")
+			scale = 100
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+		return int(math.Round(v * scale)), nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("impairment \"%s\" is not key=value", item)
+		}
+		targets := []map[string]int{ul, dl}
+		if k, ok := strings.CutPrefix(key, "ul."); ok {
+			key, targets = k, targets[:1]
+		} else if k, ok := strings.CutPrefix(key, "dl."); ok {
+			key, targets = k, targets[1:]
+		}
+
+		attrs := map[string]int{}
+		switch key {
+		case "delay", "jitter":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			attrs["IMPAIR_"+strings.ToUpper(key)] = int(d.Milliseconds())
+		case "dist":
+			found := false
+			for i, dist := range impairDists {
+				if dist == value {
+					attrs["IMPAIR_DIST"] = i
+					found = true
+				}
+			}
+			if !found {
+				return nil, nil, fmt.Errorf("unknown distribution \"%s\"", value)
+			}
+		case "loss", "reorder", "duplicate":
+			p, err := prob(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			attrs["IMPAIR_"+strings.ToUpper(key)] = p
+		case "ge":
+			// p[/r[/bad[/good]]], the rest default to 100%, 100% and 0% like in netem
+			parts := strings.Split(value, "/")
+			if len(parts) > 4 {
+				return nil, nil, fmt.Errorf("ge \"%s\" has more than p/r/bad/good", value)
+			}
+			defaults := []string{"", "100%", "100%", "0%"}
+			for i, name := range []string{"IMPAIR_GE_P", "IMPAIR_GE_R", "IMPAIR_GE_BAD", "IMPAIR_GE_GOOD"} {
+				part := defaults[i]
+				if i < len(parts) {
+					part = parts[i]
+				}
+				p, err := prob(part)
+				if err != nil {
+					return nil, nil, err
+				}
+				attrs[name] = p
+			}
+		case "rate":
+			r, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			attrs["IMPAIR_RATE"] = r
+		default:
+			return nil, nil, fmt.Errorf("unknown impairment \"%s\"", key)
+		}
+		for _, target := range targets {
+			for name, v := range attrs {
+				target[name] = v
+			}
+		}
+	}
+	return ul, dl, nil
+}
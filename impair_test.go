@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseImpairmentGE(t *testing.T) {
+	for _, c := range []struct {
+		spec            string
+		p, r, bad, good int
+	}{
+		{"ge=1%", 100, 10000, 10000, 0},
+		{"ge=1%/30%", 100, 3000, 10000, 0},
+		{"ge=1%/30%/50%", 100, 3000, 5000, 0},
+		{"ge=1%/30%/50%/2%", 100, 3000, 5000, 200},
+	} {
+		ul, dl, err := parseImpairment(c.spec)
+		if err != nil {
+			t.Errorf("%s: %v", c.spec, err)
+			continue
+		}
+		want := map[string]int{"IMPAIR_GE_P": c.p, "IMPAIR_GE_R": c.r, "IMPAIR_GE_BAD": c.bad, "IMPAIR_GE_GOOD": c.good}
+		for name, value := range want {
+			if ul[name] != value || dl[name] != value {
+				t.Errorf("%s: %s is %d/%d, not %d", c.spec, name, ul[name], dl[name], value)
+			}
+		}
+	}
+	for _, spec := range []string{"ge=1%/30%/50%/2%/1%", "ge=", "ge=1%/x"} {
+		if _, _, err := parseImpairment(spec); err == nil {
+			t.Errorf("%s accepted", spec)
+		}
+	}
+}
+
+func TestNetemArgs(t *testing.T) {
+	c := ImpairConfig{Delay: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, Dist: 1, GEP: 0.01, GER: 0.3, GEBad: 1, GEGood: 0, Rate: 5000}
+	want := []string{"qdisc", "replace", "dev", "eth0", "root", "netem",
+		"delay", "20ms", "5ms", "distribution", "normal",
+		"loss", "gemodel", "1%", "30%", "100%", "0%",
+		"rate", "5000kbit"}
+	if args := netemArgs("eth0", c); !reflect.DeepEqual(args, want) {
+		t.Errorf("tc %v", args)
+	}
+	if args := netemArgs("eth0", ImpairConfig{Loss: 0.02}); !reflect.DeepEqual(args[6:], []string{"loss", "random", "2%"}) {
+		t.Errorf("tc %v", args)
+	}
+	if args := netemArgs("eth0", ImpairConfig{}); args != nil {
+		t.Errorf("tc %v without impairments", args)
+	}
+}
+
+func TestHasDownlinkKeys(t *testing.T) {
+	for spec, want := range map[string]bool{
+		"delay=20ms,loss=1%":       false,
+		"ul.rate=5000":             false,
+		"delay=20ms, dl.ge=1%/30%": true,
+		"dl.loss=1%":               true,
+	} {
+		if got := hasDownlinkKeys(spec); got != want {
+			t.Errorf("%s: %v, expected %v", spec, got, want)
+		}
+	}
+}
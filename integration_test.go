@@ -0,0 +1,267 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func setFlags(t *testing.T, values map[string]string) {
+	for name, value := range values {
+		old := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { flag.Set(name, old) })
+	}
+}
+
+// Run a suite with a sensor, a server and the vehicles in this process,
+// against an in-process NATS server and a fake NTP server. Returns the log
+// directory.
+func runTestbed(t *testing.T, flags map[string]string, vehicles []string) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	srv, err := startEmbeddedNATS("", NATSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	ntpServer, err := NewNTPServer("127.0.0.1:0", NTPServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ntpServer.Close() })
+
+	setFlags(t, map[string]string{
+		"host":     srv.ClientURL(),
+		"ntp":      ntpServer.Addr(),
+		"cooldown": "0s",
+		"wait":     "10s",
+		"stats":    "0s",
+	})
+	setFlags(t, flags)
+
+	nodes := []*Node{}
+	names := append([]string{"sensor", "server"}, vehicles...)
+	for i, name := range names {
+		ntpClient, err := ConnectNTP(*ntpAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nc := connect(*natsAddr, NATSConfig{})
+		t.Cleanup(nc.Close)
+		var node *Node
+		switch i {
+		case 0:
+			node = newSensor(name, nc, ntpClient)
+		case 1:
+			node = newServer(name, nc, ntpClient)
+		default:
+			var closer func()
+			node, closer = newVehicle(name, nc, ntpClient)
+			t.Cleanup(closer)
+		}
+		startNode(node, NATSConfig{}, "")
+		go node.run()
+		nodes = append(nodes, node)
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.setAttr("alive", 0)
+		}
+	})
+
+	ntpClient, err := ConnectNTP(*ntpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := connect(*natsAddr, NATSConfig{})
+	t.Cleanup(nc.Close)
+	coord := newCoordinator("coordinator", nc, ntpClient)
+	tests := coord.main
+	done := make(chan struct{})
+	coord.main = func(node *Node) {
+		tests(node)
+		node.setAttr("alive", 0)
+		close(done)
+	}
+	startNode(coord, NATSConfig{}, "")
+	go coord.run()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Minute):
+		t.Fatal("suite did not finish")
+	}
+
+	logDirs, err := filepath.Glob(path.Join("logs", "*"))
+	if err != nil || len(logDirs) != 1 {
+		t.Fatalf("expected one log directory, found %v (%v)", logDirs, err)
+	}
+	return logDirs[0]
+}
+
+// Run a short suite with one vehicle and check what it saved.
+func TestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for about 15 s")
+	}
+	logDir := runTestbed(t, map[string]string{"cases": "1000,1002", "duration": "2s"}, []string{"vehicle"})
+
+	data, err := ioutil.ReadFile(path.Join(logDir, "flags.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []CaseResult
+	if err := yaml.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	expected := []CaseResult{
+		{Case: 1000, Rate: 10, Size: 1000, Features: "Baseline"},
+		{Case: 1002, Rate: 20, Size: 1000, Features: "Baseline"},
+	}
+	if len(cases) != len(expected) {
+		t.Fatalf("flags.yml has %d cases, expected %d", len(cases), len(expected))
+	}
+
+	for i, c := range cases {
+		e := expected[i]
+		if c.Case != e.Case || c.Rate != e.Rate || c.Size != e.Size || c.Features != e.Features || c.Duration != 2 {
+			t.Errorf("flags.yml case %d is %+v, expected %+v", i, c, e)
+		}
+
+		log, err := loadLog(path.Join(logDir, c.Filename))
+		if err != nil {
+			t.Fatalf("TC%d: %v", c.Case, err)
+		}
+		if len(log) == 0 || len(log) != c.Packets {
+			t.Errorf("TC%d: log has %d packets, flags.yml says %d", c.Case, len(log), c.Packets)
+		}
+		if max := c.Rate*int(c.Duration) + 2; len(log) > max {
+			t.Errorf("TC%d: log has %d packets, expected at most %d", c.Case, len(log), max)
+		}
+
+		sort.Slice(log, func(i, j int) bool { return log[i].Header.Seq < log[j].Header.Seq })
+		for j, p := range log {
+			if p.Header.Seq != int64(j) {
+				t.Errorf("TC%d: packet %d has seq %d", c.Case, j, p.Header.Seq)
+				break
+			}
+		}
+		for _, p := range log {
+			// All nodes share the clock of this process, the NTP corrected
+			// times only differ by how well each node measured the offset
+			if !(p.T1 <= p.T2 && p.T2 <= p.T3 && p.T3 <= p.T4) {
+				t.Errorf("TC%d: seq %d has timestamps out of order: %d %d %d %d", c.Case, p.Header.Seq, p.T1, p.T2, p.T3, p.T4)
+			}
+			tolerance := time.Millisecond.Nanoseconds()
+			t1, t2, t3, t4 := p.T1+p.E1, p.T2+p.E2, p.T3+p.E3, p.T4+p.E4
+			if !(t1 <= t2+tolerance && t2 <= t3+tolerance && t3 <= t4+tolerance) {
+				t.Errorf("TC%d: seq %d has corrected timestamps out of order: %d %d %d %d", c.Case, p.Header.Seq, t1, t2, t3, t4)
+			}
+			if p.Chk != 0 {
+				t.Errorf("TC%d: seq %d is corrupted", c.Case, p.Header.Seq)
+			}
+			if p.Header.FrameID != "vehicle" {
+				t.Errorf("TC%d: seq %d has frame_id \"%s\"", c.Case, p.Header.Seq, p.Header.FrameID)
+			}
+		}
+	}
+}
+
+// Run a case with two vehicles, and check the merged and per-vehicle logs.
+func TestFleet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for about 10 s")
+	}
+	logDir := runTestbed(t, map[string]string{
+		"cases":    "1000",
+		"duration": "2s",
+		"vehicles": "car1,car2",
+		"require":  "sensor,server,car1,car2",
+	}, []string{"car1", "car2"})
+
+	manifest, err := loadManifest(logDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Cases) != 1 || len(manifest.Suite.Vehicles) != 2 {
+		t.Fatalf("manifest is %+v", manifest)
+	}
+	result := manifest.Cases[0]
+	merged, err := loadLog(path.Join(logDir, result.Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Vehicles) != 2 {
+		t.Fatalf("case has vehicles %+v", result.Vehicles)
+	}
+	total := 0
+	for _, vehicle := range result.Vehicles {
+		log, err := loadLog(path.Join(logDir, vehicle.Filename))
+		if err != nil {
+			t.Fatalf("%s: %v", vehicle.Name, err)
+		}
+		if len(log) == 0 || len(log) != vehicle.Packets {
+			t.Errorf("%s: log has %d packets, the manifest says %d", vehicle.Name, len(log), vehicle.Packets)
+		}
+		for _, p := range log {
+			if p.Header.FrameID != vehicle.Name || p.Source != "sensor" {
+				t.Errorf("%s: seq %d has frame_id \"%s\" and source \"%s\"", vehicle.Name, p.Header.Seq, p.Header.FrameID, p.Source)
+				break
+			}
+		}
+		if vehicle.Loss != 0 || vehicle.E2E[0] <= 0 {
+			t.Errorf("%s: statistics are %+v", vehicle.Name, vehicle)
+		}
+		total += len(log)
+	}
+	if len(merged) != total || result.Packets != total {
+		t.Errorf("merged log has %d packets, the manifest says %d, expected %d", len(merged), result.Packets, total)
+	}
+}
+
+func TestRefusedSet(t *testing.T) {
+	srv, err := startEmbeddedNATS("", NATSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Shutdown)
+	nc := connect(srv.ClientURL(), NATSConfig{})
+	t.Cleanup(nc.Close)
+
+	sensor := NewNode("sensor", "sensor", nc, nil, func(*Node) {})
+	sensor.policy.Attrs["rate"] = "ro"
+	client := NewNode("ctl", "ctl", nc, nil, func(*Node) {})
+	if _, err := client.remote_set("sensor", "rate", 10); err == nil {
+		t.Error("setting a read-only rate did not fail")
+	}
+	if _, err := client.remote_set("sensor", "missing", 1); err == nil {
+		t.Error("setting a missing field did not fail")
+	}
+	if _, err := client.remote_set("sensor", "paused", 0); err != nil {
+		t.Error(err)
+	}
+	if _, err := client.remote_set_log("sensor", []Packet{}); err == nil {
+		t.Error("replacing the log while running did not fail")
+	}
+	if err := (Fleet{Sensors: []string{"sensor"}}).configure(client, 10, 100, nil, nil); err == nil {
+		t.Error("configuring a case with a read-only rate did not fail")
+	}
+}
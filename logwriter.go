@@ -0,0 +1,356 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var logFormatFlag = flag.String("format", "csv", "Format of packet logs: csv, csv.gz or parquet")
+
+// Writes packets to a log file. The format is decided by the file extension,
+// see `logFormats`.
+type LogWriter interface {
+	Write(p Packet) error
+	Close() error
+}
+
+var logFormats = map[string]func(io.WriteCloser) LogWriter{
+	"csv":     newCSVLogWriter,
+	"csv.gz":  newGzipCSVLogWriter,
+	"parquet": newParquetLogWriter,
+}
+
+func logExtension(format string) (string, error) {
+	if _, ok := logFormats[format]; !ok {
+		return "", fmt.Errorf("unsupported log format \"%s\"", format)
+	}
+	return "." + format, nil
+}
+
+// The format of a log file, defaults to csv for unknown extensions.
+func logFormat(filename string) string {
+	for format := range logFormats {
+		if strings.HasSuffix(filename, "."+format) && format != "csv" {
+			return format
+		}
+	}
+	return "csv"
+}
+
+func createLog(filename string) (LogWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return logFormats[logFormat(filename)](file), nil
+}
+
+func writeLog(log []Packet, filename string) error {
+	w, err := createLog(filename)
+	if err != nil {
+		return err
+	}
+	for _, packet := range log {
+		if err := w.Write(packet); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func save(log []Packet, filename string) {
+	if err := writeLog(log, filename); err != nil {
+		panic(err)
+	}
+}
+
+var csvHeader = []string{"t1", "t2", "t3", "t4", "e1", "e2", "e3", "e4", "x", "y", "yaw", "vel", "lat", "lon", "seq", "valid", "frame_id", "cell_id", "pci", "rsrp", "rsrq", "sinr", "band", "fix", "hdop", "gnss_offset", "payload", "size", "frame", "frame_type", "fragment", "fragments", "deadline", "source"}
+
+func csvRecord(packet Packet) []string {
+	t1 := strconv.FormatInt(packet.T1, 10)
+	t2 := strconv.FormatInt(packet.T2, 10)
+	t3 := strconv.FormatInt(packet.T3, 10)
+	t4 := strconv.FormatInt(packet.T4, 10)
+	e1 := strconv.FormatInt(packet.E1, 10)
+	e2 := strconv.FormatInt(packet.E2, 10)
+	e3 := strconv.FormatInt(packet.E3, 10)
+	e4 := strconv.FormatInt(packet.E4, 10)
+	x := strconv.FormatFloat(packet.X, 'f', -1, 64)
+	y := strconv.FormatFloat(packet.Y, 'f', -1, 64)
+	yaw := strconv.FormatFloat(packet.Yaw, 'f', -1, 64)
+	vel := strconv.FormatFloat(float64(packet.V), 'f', -1, 64)
+	lat := strconv.FormatFloat(packet.Latitude, 'f', -1, 64)
+	lon := strconv.FormatFloat(packet.Longitude, 'f', -1, 64)
+	seq := strconv.FormatInt(packet.Header.Seq, 10)
+	chk := strconv.Itoa(packet.Chk)
+	frame_id := packet.Header.FrameID
+	cell_id := strconv.FormatInt(packet.CellID, 10)
+	pci := strconv.Itoa(packet.PCI)
+	rsrp := strconv.FormatFloat(packet.RSRP, 'f', -1, 64)
+	rsrq := strconv.FormatFloat(packet.RSRQ, 'f', -1, 64)
+	sinr := strconv.FormatFloat(packet.SINR, 'f', -1, 64)
+	band := strconv.Itoa(packet.Band)
+	fix := strconv.Itoa(packet.Fix)
+	hdop := strconv.FormatFloat(packet.HDOP, 'f', -1, 64)
+	gnss_offset := strconv.FormatInt(packet.GNSSOffset, 10)
+	payload := packet.Payload
+	size := strconv.Itoa(packet.Size)
+	frame := strconv.FormatInt(packet.Frame, 10)
+	frame_type := packet.FrameType
+	fragment := strconv.Itoa(packet.Fragment)
+	fragments := strconv.Itoa(packet.Fragments)
+	deadline := strconv.FormatInt(packet.Deadline, 10)
+	source := packet.Source
+
+	return []string{t1, t2, t3, t4, e1, e2, e3, e4, x, y, yaw, vel, lat, lon, seq, chk, frame_id, cell_id, pci, rsrp, rsrq, sinr, band, fix, hdop, gnss_offset, payload, size, frame, frame_type, fragment, fragments, deadline, source}
+}
+
+type csvLogWriter struct {
+	file io.WriteCloser
+	csv  *csv.Writer
+}
+
+func newCSVLogWriter(file io.WriteCloser) LogWriter {
+	w := &csvLogWriter{file: file, csv: csv.NewWriter(file)}
+	w.csv.Write(csvHeader)
+	return w
+}
+
+func (w *csvLogWriter) Write(p Packet) error {
+	return w.csv.Write(csvRecord(p))
+}
+
+func (w *csvLogWriter) Close() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Closes both the gzip stream and the file underneath it.
+type gzipFile struct {
+	*gzip.Writer
+	file io.Closer
+}
+
+func (f gzipFile) Close() error {
+	if err := f.Writer.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+func newGzipCSVLogWriter(file io.WriteCloser) LogWriter {
+	return newCSVLogWriter(gzipFile{gzip.NewWriter(file), file})
+}
+
+// Same columns as the CSV, but typed, so int64 timestamps keep their precision.
+type packetRow struct {
+	T1         int64   `parquet:"t1"`
+	T2         int64   `parquet:"t2"`
+	T3         int64   `parquet:"t3"`
+	T4         int64   `parquet:"t4"`
+	E1         int64   `parquet:"e1"`
+	E2         int64   `parquet:"e2"`
+	E3         int64   `parquet:"e3"`
+	E4         int64   `parquet:"e4"`
+	X          float64 `parquet:"x"`
+	Y          float64 `parquet:"y"`
+	Yaw        float64 `parquet:"yaw"`
+	Vel        float32 `parquet:"vel"`
+	Lat        float64 `parquet:"lat"`
+	Lon        float64 `parquet:"lon"`
+	Seq        int64   `parquet:"seq"`
+	Valid      int64   `parquet:"valid"`
+	FrameID    string  `parquet:"frame_id,dict"`
+	CellID     int64   `parquet:"cell_id"`
+	PCI        int64   `parquet:"pci"`
+	RSRP       float64 `parquet:"rsrp"`
+	RSRQ       float64 `parquet:"rsrq"`
+	SINR       float64 `parquet:"sinr"`
+	Band       int64   `parquet:"band"`
+	Fix        int64   `parquet:"fix"`
+	HDOP       float64 `parquet:"hdop"`
+	GNSSOffset int64   `parquet:"gnss_offset"`
+	Payload    string  `parquet:"payload,dict"`
+	Size       int64   `parquet:"size"`
+	Frame      int64   `parquet:"frame"`
+	FrameType  string  `parquet:"frame_type,dict"`
+	Fragment   int64   `parquet:"fragment"`
+	Fragments  int64   `parquet:"fragments"`
+	Deadline   int64   `parquet:"deadline"`
+	Source     string  `parquet:"source,dict"`
+}
+
+func newPacketRow(p Packet) packetRow {
+	return packetRow{
+		T1: p.T1, T2: p.T2, T3: p.T3, T4: p.T4,
+		E1: p.E1, E2: p.E2, E3: p.E3, E4: p.E4,
+		X: p.X, Y: p.Y, Yaw: p.Yaw, Vel: p.V,
+		Lat: p.Latitude, Lon: p.Longitude,
+		Seq: p.Header.Seq, Valid: int64(p.Chk), FrameID: p.Header.FrameID,
+		CellID: p.CellID, PCI: int64(p.PCI), RSRP: p.RSRP, RSRQ: p.RSRQ, SINR: p.SINR, Band: int64(p.Band),
+		Fix: int64(p.Fix), HDOP: p.HDOP, GNSSOffset: p.GNSSOffset,
+		Payload: p.Payload, Size: int64(p.Size),
+		Frame: p.Frame, FrameType: p.FrameType, Fragment: int64(p.Fragment), Fragments: int64(p.Fragments),
+		Deadline: p.Deadline, Source: p.Source,
+	}
+}
+
+func (r packetRow) packet() Packet {
+	p := Packet{
+		T1: r.T1, T2: r.T2, T3: r.T3, T4: r.T4,
+		E1: r.E1, E2: r.E2, E3: r.E3, E4: r.E4,
+		X: r.X, Y: r.Y, Yaw: r.Yaw, V: r.Vel,
+		Latitude: r.Lat, Longitude: r.Lon, Chk: int(r.Valid),
+		Payload: r.Payload, Size: int(r.Size), Deadline: r.Deadline, Source: r.Source,
+	}
+	p.Header.Seq = r.Seq
+	p.Header.FrameID = r.FrameID
+	p.RadioContext = RadioContext{CellID: r.CellID, PCI: int(r.PCI), RSRP: r.RSRP, RSRQ: r.RSRQ, SINR: r.SINR, Band: int(r.Band)}
+	p.GNSSContext = GNSSContext{Fix: int(r.Fix), HDOP: r.HDOP, GNSSOffset: r.GNSSOffset}
+	p.FrameContext = FrameContext{Frame: r.Frame, FrameType: r.FrameType, Fragment: int(r.Fragment), Fragments: int(r.Fragments)}
+	return p
+}
+
+type parquetLogWriter struct {
+	file    io.WriteCloser
+	parquet *parquet.GenericWriter[packetRow]
+}
+
+func newParquetLogWriter(file io.WriteCloser) LogWriter {
+	return &parquetLogWriter{
+		file:    file,
+		parquet: parquet.NewGenericWriter[packetRow](file, parquet.Compression(&parquet.Zstd)),
+	}
+}
+
+func (w *parquetLogWriter) Write(p Packet) error {
+	_, err := w.parquet.Write([]packetRow{newPacketRow(p)})
+	return err
+}
+
+func (w *parquetLogWriter) Close() error {
+	if err := w.parquet.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Read a packet log written by `save`, in any of the formats. CSV columns are
+// matched by name, so logs from older versions without some columns load too.
+func loadLog(filename string) ([]Packet, error) {
+	if logFormat(filename) == "parquet" {
+		rows, err := parquet.ReadFile[packetRow](filename)
+		if err != nil {
+			return nil, err
+		}
+		log := make([]Packet, len(rows))
+		for i, r := range rows {
+			log[i] = r.packet()
+		}
+		return log, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if logFormat(filename) == "csv.gz" {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return readCSVLog(r)
+}
+
+func readCSVLog(r io.Reader) ([]Packet, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	log := []Packet{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return log, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var parseErr error
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		integer := func(name string) int64 {
+			s := field(name)
+			if len(s) == 0 {
+				return 0
+			}
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil && parseErr == nil {
+				parseErr = fmt.Errorf("column %s: %v", name, err)
+			}
+			return v
+		}
+		float := func(name string) float64 {
+			s := field(name)
+			if len(s) == 0 {
+				return 0
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil && parseErr == nil {
+				parseErr = fmt.Errorf("column %s: %v", name, err)
+			}
+			return v
+		}
+
+		p := Packet{}
+		p.T1, p.T2, p.T3, p.T4 = integer("t1"), integer("t2"), integer("t3"), integer("t4")
+		p.E1, p.E2, p.E3, p.E4 = integer("e1"), integer("e2"), integer("e3"), integer("e4")
+		p.X, p.Y, p.Yaw, p.V = float("x"), float("y"), float("yaw"), float32(float("vel"))
+		p.Latitude, p.Longitude = float("lat"), float("lon")
+		p.Header.Seq = integer("seq")
+		p.Chk = int(integer("valid"))
+		p.Header.FrameID = field("frame_id")
+		p.CellID, p.PCI, p.Band = integer("cell_id"), int(integer("pci")), int(integer("band"))
+		p.RSRP, p.RSRQ, p.SINR = float("rsrp"), float("rsrq"), float("sinr")
+		p.Fix, p.HDOP, p.GNSSOffset = int(integer("fix")), float("hdop"), integer("gnss_offset")
+		p.Payload, p.Size = field("payload"), int(integer("size"))
+		p.Frame, p.FrameType = integer("frame"), field("frame_type")
+		p.Fragment, p.Fragments = int(integer("fragment")), int(integer("fragments"))
+		p.Deadline = integer("deadline")
+		p.Source = field("source")
+		if parseErr != nil {
+			return nil, fmt.Errorf("line %d: %v", len(log)+2, parseErr)
+		}
+		log = append(log, p)
+	}
+}
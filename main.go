@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"path"
 	"time"
 
 	"github.com/beevik/ntp"
@@ -12,9 +14,13 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-func connect(host string) *nats.EncodedConn {
+func connect(host string, conf NATSConfig) *nats.EncodedConn {
 
-	nc, err := nats.Connect(host, nats.Timeout(1*time.Minute))
+	opts, err := conf.options()
+	if err != nil {
+		panic(err)
+	}
+	nc, err := nats.Connect(host, append(opts, nats.Timeout(1*time.Minute))...)
 	if err != nil {
 		panic(err)
 	}
@@ -26,21 +32,337 @@ func connect(host string) *nats.EncodedConn {
 }
 
 var nodeName = flag.String("name", "", "Name of node, defaults to same name as type.")
-var nodeType = flag.String("type", "", "Type of node, e.g. server")
+var nodeType = flag.String("type", "", "Type of node, e.g. server, rsu to broadcast to vehicles, ntp for a simulated NTP server, or all-in-one to run all of them in this process")
 var natsAddr = flag.String("host", "10.20.33.130", "URL to NATS server host.")
 var ntpAddr = flag.String("ntp", "10.47.6.47", "URL to NTP server.")
 var enableROS = flag.Bool("ros", false, "Enable ROS.")
+var sourcesFlag = flag.String("from", "", "Which nodes does a server or vehicle receive data from? Defaults to sensor and server respectively")
+
+// Names of the nodes in -from, or `def`.
+func sources(def string) []string {
+	if len(*sourcesFlag) == 0 {
+		return []string{def}
+	}
+	return splitNames(*sourcesFlag)
+}
+
+// The next packet of a sensor or RSU, of DATA_SIZE bytes and stamped with T1.
+func (n *Node) nextPacket() Packet {
+	seq, _ := n.getAttr("DATA_SEQ")
+	message := Packet{}
+	var data []byte
+	var err error
+	if n.payload != nil {
+		// Stamped before the message is built, so that is part of the latency
+		message.Header.Stamp = time.Now().UnixNano()
+		message.T1 = time.Now().UnixNano()
+		data, err = n.payload.next()
+		message.Payload = n.payload.label()
+	} else {
+		size, _ := n.getAttr("DATA_SIZE")
+		data, err = RandomBytes(size)
+		message.Header.Stamp = time.Now().UnixNano()
+		message.T1 = time.Now().UnixNano()
+	}
+	if err != nil {
+		panic(err)
+	}
+	message.Header.Seq = int64(seq)
+	message.Source = n.name
+	message.Data = data
+	message.Size = len(data)
+	message.Chk = Checksum(data, 0)
+	message.E1 = n.ntpClient.GetOffset()
+	n.stampDeadline(&message)
+
+	n.setAttr("DATA_SEQ", seq+1)
+	return message
+}
+
+func newSensor(name string, nc *nats.EncodedConn, ntpClient *NTPClient) *Node {
+	node := NewNode(name, "sensor", nc, ntpClient, func(node *Node) {
+		packets := node.nextPackets()
+		for i := range packets {
+			node.link.Send(&packets[i], func(p *Packet) {
+				node.nc.Publish(fmt.Sprintf("%s.data", node.name), p)
+			})
+			node.metrics.sent(len(packets[i].Data))
+		}
+	})
+	node.setAttr("DATA_SIZE", 1000)
+	node.setAttr("DATA_SEQ", 0)
+	node.setAttr("DEADLINE", int(deadlineFlag.Milliseconds()))
+	node.link = NewLink(node)
+	node.link.replay("ul")
+	node.usePayload()
+	node.useStream()
+	return node
+}
+
+func newServer(name string, nc *nats.EncodedConn, ntpClient *NTPClient) *Node {
+	node := NewNode(name, "server", nc, ntpClient, func(node *Node) {})
+	node.setAttr("COMPUTE_TIME", 0)
+	node.link = NewLink(node)
+	node.link.replay("dl")
+	receive := func(p *Packet) {
+		p.T2 = time.Now().UnixNano()
+		p.E2 = node.ntpClient.GetOffset()
+		node.metrics.received(len(p.Data))
+		node.metrics.observe("ul", p.Uplink())
+		if c_time, ok := node.getAttr("COMPUTE_TIME"); ok {
+			time.Sleep(time.Duration(c_time * 1e6))
+		}
+		p.T3 = time.Now().UnixNano()
+		p.E3 = node.ntpClient.GetOffset()
+		node.link.Send(p, func(p *Packet) {
+			node.nc.Publish(fmt.Sprintf("%s.data", node.name), p)
+		})
+		node.metrics.sent(len(p.Data))
+	}
+	for _, source := range sources("sensor") {
+		node.nc.Subscribe(fmt.Sprintf("%s.data", source), receive)
+	}
+	return node
+}
+
+// The returned function closes the ROS subscriptions, if any.
+func newVehicle(name string, nc *nats.EncodedConn, ntpClient *NTPClient) (*Node, func()) {
+
+	feed := &VehicleFeed{}
+	closers := []func(){}
+
+	if *enableROS {
+		n, err := goroslib.NewNode(goroslib.NodeConf{
+			Name:          "goroslib_sub",
+			MasterAddress: "localhost:11311",
+		})
+		if err != nil {
+			panic(err)
+		}
+		closers = append(closers, n.Close)
+
+		subState, err := goroslib.NewSubscriber(goroslib.SubscriberConf{
+			Node:     n,
+			Topic:    "state",
+			Callback: func(msg *VehicleState) { feed.setState(*msg) },
+		})
+		if err != nil {
+			panic(err)
+		}
+		closers = append(closers, subState.Close)
+
+		subGps, err := goroslib.NewSubscriber(goroslib.SubscriberConf{
+			Node:     n,
+			Topic:    "gps/filtered",
+			Callback: func(msg *sensor_msgs.NavSatFix) { feed.setGPS(*msg) },
+		})
+		if err != nil {
+			panic(err)
+		}
+		closers = append(closers, subGps.Close)
+	}
+
+	node := NewNode(name, "vehicle", nc, ntpClient, func(node *Node) {})
+	node.radio = &RadioMonitor{}
+	node.stats = NewRollingStats(*statsWindow) // before packets can arrive
+	node.nc.Subscribe(fmt.Sprintf("%s.get.handovers", name), node.get_srv_handovers_cb)
+	receive := func(p *Packet) {
+		node.logsMu.Lock() // sources deliver concurrently
+		defer node.logsMu.Unlock()
+		p.Header.FrameID = node.name
+		if len(p.Payload) != 0 {
+			if _, err := decodePayload(p.Payload, p.Data); err != nil {
+				fmt.Printf("Payload error in packet %d: %v\n", p.Header.Seq, err)
+			}
+		}
+		p.T4 = time.Now().UnixNano()
+		p.E4 = node.ntpClient.GetOffset()
+		node.metrics.received(len(p.Data))
+		node.metrics.observe("dl", p.Downlink())
+		node.metrics.observe("e2e", p.EndToEnd())
+		state, gps := feed.get()
+		p.X = state.X
+		p.Y = state.Y
+		p.Yaw = state.Yaw
+		p.V = state.V
+		p.Latitude = gps.Latitude
+		p.Longitude = gps.Longitude
+		p.RadioContext = node.radio.Current()
+		p.GNSSContext = feed.getGNSS()
+		p.Size = len(p.Data)
+		p.Chk = Checksum(p.Data, p.Chk) // NOTE: After this, if chk == 0 then it's good. The message was not corrupted.
+		p.Data = []byte{}               // NOTE: We empty it so all data isn't stored. Use for something else? Maybe time sync error?
+		node.logs = append(node.logs, *p)
+		node.stats.add(*p)
+	}
+	for _, source := range sources("server") {
+		node.nc.Subscribe(fmt.Sprintf("%s.data", source), receive)
+	}
+	if len(*multicastFlag) != 0 {
+		go node.listenMulticast(*multicastFlag, receive)
+	}
+	if len(*gnssFlag) != 0 {
+		if len(*motionFlag) != 0 {
+			panic("Use either -gnss or -motion, not both.")
+		}
+		go node.watchGNSS(*gnssFlag, feed)
+	}
+	if !*enableROS && len(*motionFlag) != 0 {
+		motion, lat0, lon0, err := newMotion(*motionFlag)
+		if err != nil {
+			panic(err)
+		}
+		go node.simulateMotion(feed, motion, lat0, lon0)
+	}
+	if len(*modemFlag) != 0 {
+		modem, err := openModem(*modemFlag)
+		if err != nil {
+			panic(err)
+		}
+		go node.watchRadio(modem, feed, *modemPoll)
+	}
+	if *statsInterval > 0 {
+		go node.publishStats(*statsInterval)
+	}
+	if *positionInterval > 0 {
+		go node.publishPosition(*positionInterval, func() Position {
+			state, gps := feed.get()
+			return Position{X: state.X, Y: state.Y, Latitude: gps.Latitude, Longitude: gps.Longitude}
+		})
+	}
+
+	return node, func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+}
+
+func newCoordinator(name string, nc *nats.EncodedConn, ntpClient *NTPClient) *Node {
+	node := NewNode(name, "coordinator", nc, ntpClient, coordinator())
+	node.setAttr("paused", 0)
+	return node
+}
+
+// Apply the access settings, and start the background tasks every node has.
+func startNode(node *Node, natsConf NATSConfig, metrics string) {
+	var err error
+	node.allow = natsConf.Allow
+	if len(*policyFlag) != 0 {
+		node.policy, err = loadPolicy(*policyFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(*auditDirFlag) != 0 {
+		if err := node.audit.persist(path.Join(*auditDirFlag, node.name+".jsonl")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if node.ntpClient != nil {
+		go node.ntpClient.QueryLoop(func(r ntp.Response) bool {
+			return node.isAlive()
+		})
+	}
+
+	node.announce()
+	if len(metrics) != 0 {
+		node.serveMetrics(metrics)
+	}
+}
+
+// Run a sensor, server and vehicle in the background and a coordinator in
+// the foreground, each with its own connection. Returns when the tests are
+// done.
+func allInOne(natsConf NATSConfig) {
+	for _, kind := range []string{"sensor", "server", "vehicle"} {
+		ntpClient, err := ConnectNTP(*ntpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nc := connect(*natsAddr, natsConf)
+		var node *Node
+		switch kind {
+		case "sensor":
+			node = newSensor(kind, nc, ntpClient)
+		case "server":
+			node = newServer(kind, nc, ntpClient)
+		case "vehicle":
+			var closer func()
+			node, closer = newVehicle(kind, nc, ntpClient)
+			defer closer()
+		}
+		startNode(node, natsConf, "")
+		go node.run()
+	}
+
+	ntpClient, err := ConnectNTP(*ntpAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	node := newCoordinator("coordinator", connect(*natsAddr, natsConf), ntpClient)
+	tests := node.main
+	node.main = func(node *Node) {
+		tests(node)
+		node.setAttr("alive", 0)
+	}
+	startNode(node, natsConf, *metricsAddr)
+	node.run()
+}
 
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "import" {
+		os.Exit(importRadio(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "export" {
+		os.Exit(export(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "coverage" {
+		os.Exit(coverage(flag.Args()[1:]))
+	}
+
 	if len(*nodeName) == 0 {
 		*nodeName = *nodeType
 	}
 
+	natsConf, err := loadNATSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *embeddedNATS || *nodeType == "all-in-one" {
+		srv, err := startEmbeddedNATS(*natsAddr, natsConf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer srv.Shutdown()
+		*natsAddr = srv.ClientURL()
+		fmt.Printf("Embedded NATS server listening on %s\n", *natsAddr)
+	}
+
+	if flag.Arg(0) == "ctl" {
+		os.Exit(ctl(connect(*natsAddr, natsConf), flag.Args()[1:]))
+	}
+
+	if *nodeType == "all-in-one" {
+		fmt.Println("Starting sensor, server, vehicle and coordinator!")
+		allInOne(natsConf)
+		return
+	}
+
 	fmt.Printf("Starting %s!\n", *nodeName)
 
-	natsClient := connect(*natsAddr)
+	natsClient := connect(*natsAddr, natsConf)
+
+	if *nodeType == "ntp" {
+		node := newNTPServer(*nodeName, natsClient)
+		startNode(node, natsConf, *metricsAddr)
+		node.run()
+		return
+	}
 
 	ntpClient, err := ConnectNTP(*ntpAddr)
 	if err != nil {
@@ -49,102 +371,22 @@ func main() {
 
 	var node *Node
 	if *nodeType == "coordinator" {
-		node = NewNode(*nodeName, natsClient, ntpClient, coordinator())
-		node.attr["paused"] = 0
+		node = newCoordinator(*nodeName, natsClient, ntpClient)
 	} else if *nodeType == "sensor" {
-		node = NewNode(*nodeName, natsClient, ntpClient, func(node *Node) {
-			size, _ := node.attr["DATA_SIZE"]
-			seq, _ := node.attr["DATA_SEQ"]
-			data, err := RandomBytes(size)
-			if err != nil {
-				panic(err)
-			}
-
-			message := Packet{}
-			message.Header.Stamp = time.Now().UnixNano()
-			message.Header.Seq = int64(seq)
-			message.Data = data
-			message.Chk = Checksum(data, 0)
-			message.T1 = time.Now().UnixNano()
-			message.E1 = node.ntpClient.Resp.ClockOffset.Nanoseconds()
-			node.nc.Publish(fmt.Sprintf("%s.data", node.name), &message)
-
-			node.attr["DATA_SEQ"] += 1
-		})
-		node.attr["DATA_SIZE"] = 1000
-		node.attr["DATA_SEQ"] = 0
+		node = newSensor(*nodeName, natsClient, ntpClient)
 	} else if *nodeType == "server" {
-		node = NewNode(*nodeName, natsClient, ntpClient, func(node *Node) {})
-		node.attr["COMPUTE_TIME"] = 0
-		node.nc.Subscribe("sensor.data", func(p *Packet) {
-			p.T2 = time.Now().UnixNano()
-			p.E2 = node.ntpClient.GetOffset()
-			if c_time, ok := node.attr["COMPUTE_TIME"]; ok {
-				time.Sleep(time.Duration(c_time * 1e6))
-			}
-			p.T3 = time.Now().UnixNano()
-			p.E3 = node.ntpClient.GetOffset()
-			node.nc.Publish(fmt.Sprintf("%s.data", node.name), &p)
-		})
+		node = newServer(*nodeName, natsClient, ntpClient)
+	} else if *nodeType == "rsu" {
+		node = newRSU(*nodeName, natsClient, ntpClient)
 	} else if *nodeType == "vehicle" {
-
-		var state VehicleState
-		var gps sensor_msgs.NavSatFix
-
-		if *enableROS {
-			n, err := goroslib.NewNode(goroslib.NodeConf{
-				Name:          "goroslib_sub",
-				MasterAddress: "localhost:11311",
-			})
-			if err != nil {
-				panic(err)
-			}
-			defer n.Close()
-
-			subState, err := goroslib.NewSubscriber(goroslib.SubscriberConf{
-				Node:     n,
-				Topic:    "state",
-				Callback: func(msg *VehicleState) { state = *msg },
-			})
-			if err != nil {
-				panic(err)
-			}
-			defer subState.Close()
-
-			subGps, err := goroslib.NewSubscriber(goroslib.SubscriberConf{
-				Node:     n,
-				Topic:    "gps/filtered",
-				Callback: func(msg *sensor_msgs.NavSatFix) { gps = *msg },
-			})
-			if err != nil {
-				panic(err)
-			}
-			defer subGps.Close()
-		}
-
-		node = NewNode(*nodeName, natsClient, ntpClient, func(node *Node) {})
-		node.nc.Subscribe("server.data", func(p *Packet) {
-			p.Header.FrameID = node.name
-			p.T4 = time.Now().UnixNano()
-			p.E4 = node.ntpClient.GetOffset()
-			p.X = state.X
-			p.Y = state.Y
-			p.Yaw = state.Yaw
-			p.V = state.V
-			p.Latitude = gps.Latitude
-			p.Longitude = gps.Longitude
-			p.Chk = Checksum(p.Data, p.Chk) // NOTE: After this, if chk == 0 then it's good. The message was not corrupted.
-			p.Data = []byte{}               // NOTE: We empty it so all data isn't stored. Use for something else? Maybe time sync error?
-			node.logs = append(node.logs, *p)
-		})
+		var closer func()
+		node, closer = newVehicle(*nodeName, natsClient, ntpClient)
+		defer closer()
 	} else {
 		log.Fatalf("Unsupported node type \"%s\".", *nodeType)
 	}
 
-	go node.ntpClient.QueryLoop(func(r ntp.Response) bool {
-		val, _ := node.attr["alive"]
-		return val != 0
-	})
+	startNode(node, natsConf, *metricsAddr)
 
 	node.run()
 }
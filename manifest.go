@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Bump when fields are renamed or change meaning, additions are fine.
+const manifestSchemaVersion = 1
+
+const manifestFile = "manifest.yml"
+
+// Everything needed to interpret the logs of a test suite. It is rewritten
+// to the suite's log directory after every case.
+type Manifest struct {
+	SchemaVersion int          `yaml:"schema_version"`
+	StartTime     string       `yaml:"start_time"`
+	Suite         SuiteParams  `yaml:"suite"`
+	Environment   Environment  `yaml:"environment"`
+	Cases         []CaseResult `yaml:"cases"`
+}
+
+type SuiteParams struct {
+	Cases    []int    `yaml:"cases"`
+	Rates    []int    `yaml:"rates"`    // [Hz]
+	Sizes    []int    `yaml:"sizes"`    // [B]
+	Duration float64  `yaml:"duration"` // [s]
+	Cooldown float64  `yaml:"cooldown"` // [s]
+	Reruns   uint     `yaml:"reruns"`
+	Manual   bool     `yaml:"manual"`
+	Required []string `yaml:"required"`
+	Format   string   `yaml:"format"`
+	Impair   []string `yaml:"impair,omitempty"`
+	Vehicles []string `yaml:"vehicles,omitempty"`
+	Sensors  []string `yaml:"sensors,omitempty"`
+	Servers  []string `yaml:"servers,omitempty"`
+}
+
+type Environment struct {
+	Version   string     `yaml:"version"`
+	Commit    string     `yaml:"commit"`
+	Modified  bool       `yaml:"modified"`
+	GoVersion string     `yaml:"go_version"`
+	Host      string     `yaml:"host"`
+	Transport string     `yaml:"transport"`
+	NATS      string     `yaml:"nats"`
+	NTP       string     `yaml:"ntp"`
+	Nodes     []NodeInfo `yaml:"nodes"`
+}
+
+type NodeInfo struct {
+	Name    string `yaml:"name"`
+	Type    string `yaml:"type"`
+	Version string `yaml:"version"`
+	Host    string `yaml:"host"`
+	NTP     string `yaml:"ntp"`
+}
+
+// The result of running one test case. The fields up to and including
+// Filename are the ones flags.yml has always had.
+type CaseResult struct {
+	Case       int              `yaml:"case"`
+	Rate       int              `yaml:"rate"` // [Hz]
+	Size       int              `yaml:"size"` // [B]
+	Load       int              `yaml:"load"` // [%]
+	Mobility   bool             `yaml:"mobility"`
+	Features   string           `yaml:"features"`
+	Datetime   string           `yaml:"datetime"`
+	Duration   float64          `yaml:"duration"` // [s]
+	Cooldown   float64          `yaml:"cooldown"` // [s]
+	Filename   string           `yaml:"filename"`
+	Run        int              `yaml:"run"`
+	Started    string           `yaml:"started"`
+	Finished   string           `yaml:"finished"`
+	Packets    int              `yaml:"packets"`
+	Impairment string           `yaml:"impairment,omitempty"`
+	Handovers  []Handover       `yaml:"handovers,omitempty"`
+	RadioFile  string           `yaml:"radio_file,omitempty"` // written by wp3go import
+	AoIFile    string           `yaml:"aoi_file,omitempty"`   // age of information of the vehicles over time
+	Vehicles   []VehicleResult  `yaml:"vehicles,omitempty"`
+	Broadcast  *BroadcastResult `yaml:"broadcast,omitempty"`
+}
+
+// What one vehicle received during a case. Filename is only set when the
+// case had more than one vehicle.
+type VehicleResult struct {
+	Name     string        `yaml:"name"`
+	Filename string        `yaml:"filename,omitempty"`
+	Packets  int           `yaml:"packets"`
+	Rate     float64       `yaml:"rate"` // [Hz]
+	Loss     float64       `yaml:"loss"`
+	UL       [3]float64    `yaml:"ul"`                 // p50, p95, p99 [ms]
+	DL       [3]float64    `yaml:"dl"`                 // p50, p95, p99 [ms]
+	E2E      [3]float64    `yaml:"e2e"`                // p50, p95, p99 [ms]
+	PDR      float64       `yaml:"pdr,omitempty"`      // of the broadcast packets
+	IRT      []float64     `yaml:"irt,flow,omitempty"` // p50, p95, p99 inter-reception time [ms]
+	MaxIRT   float64       `yaml:"max_irt,omitempty"`  // [ms]
+	Missed   float64       `yaml:"missed"`             // fraction not received by their deadline, lost included
+	Late     float64       `yaml:"late"`               // fraction of the received ones after their deadline
+	AoI      float64       `yaml:"aoi"`                // time average age of information [ms]
+	PeakAoI  float64       `yaml:"peak_aoi"`           // [ms]
+	Stream   *StreamResult `yaml:"stream,omitempty"`   // frames, if the sensor streamed
+}
+
+// Awareness of the vehicles of a broadcast case, see broadcastAwareness.
+type BroadcastResult struct {
+	Senders   []string `yaml:"senders,flow"`
+	Sent      int      `yaml:"sent"`      // by all of them
+	Window    float64  `yaml:"window"`    // [ms]
+	Awareness float64  `yaml:"awareness"` // mean fraction of vehicles aware of a message
+	Complete  float64  `yaml:"complete"`  // fraction of messages all vehicles were aware of
+	Filename  string   `yaml:"filename"`  // awareness per message
+}
+
+// Describe the binary and host the coordinator is running on.
+func currentEnvironment() Environment {
+	env := Environment{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		Transport: "nats",
+		NATS:      *natsAddr,
+		NTP:       *ntpAddr,
+	}
+	env.Host, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				env.Commit = setting.Value
+			case "vcs.modified":
+				env.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return env
+}
+
+func nodeInfos(nodes []Heartbeat) []NodeInfo {
+	infos := []NodeInfo{}
+	for _, hb := range nodes {
+		infos = append(infos, NodeInfo{Name: hb.Name, Type: hb.Type, Version: hb.Version, Host: hb.Host, NTP: hb.NTP.Server})
+	}
+	return infos
+}
+
+func loadManifest(dir string) (Manifest, error) {
+	var m Manifest
+	data, err := ioutil.ReadFile(path.Join(dir, manifestFile))
+	if err != nil {
+		return m, err
+	}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return m, err
+	}
+	if m.SchemaVersion > manifestSchemaVersion {
+		return m, fmt.Errorf("manifest schema version %d is newer than supported (%d)", m.SchemaVersion, manifestSchemaVersion)
+	}
+	return m, nil
+}
+
+// Write the manifest, and the list of cases to flags.yml for older readers
+// such as the notebook.
+func (m Manifest) save(dir string) error {
+	if err := writeYAML(path.Join(dir, manifestFile), "", m); err != nil {
+		return err
+	}
+	cases := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(m.Suite.Cases)), ","), "[]")
+	header := fmt.Sprintf("# Test suite started at %s with following test(s): %s\n# Generated from %s, which has more information.\n", m.StartTime, cases, manifestFile)
+	return writeYAML(path.Join(dir, "flags.yml"), header, m.Cases)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metricsAddr = flag.String("metrics", "", "Serve Prometheus metrics on this address, e.g. :9100")
+
+// Upper bounds of the latency histogram buckets [s]
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.02, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Seconds over which the sent and received rates are measured.
+const rateWindow = 5
+
+// Events per second over the last complete seconds, counted in one bin per
+// second.
+type rateMeter struct {
+	seconds [rateWindow + 1]int64
+	counts  [rateWindow + 1]uint64
+}
+
+func (r *rateMeter) add(now time.Time) {
+	s := now.Unix()
+	i := s % int64(len(r.seconds))
+	if r.seconds[i] != s {
+		r.seconds[i], r.counts[i] = s, 0
+	}
+	r.counts[i]++
+}
+
+func (r *rateMeter) rate(now time.Time) float64 {
+	s := now.Unix()
+	sum := uint64(0)
+	for i, second := range r.seconds {
+		if second >= s-rateWindow && second < s {
+			sum += r.counts[i]
+		}
+	}
+	return float64(sum) / rateWindow
+}
+
+// Counters a node updates while running, exposed in the Prometheus text
+// format. Gauges such as the configured rate and NTP offset are read when
+// scraped.
+type Metrics struct {
+	mu              sync.Mutex
+	packetsSent     uint64
+	packetsReceived uint64
+	bytesSent       uint64
+	bytesReceived   uint64
+	sendRate        rateMeter
+	receiveRate     rateMeter
+	lastLatency     map[string]float64
+	latency         map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{lastLatency: map[string]float64{}, latency: map[string]*histogram{}}
+}
+
+func (m *Metrics) sent(bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packetsSent++
+	m.bytesSent += uint64(bytes)
+	m.sendRate.add(time.Now())
+}
+
+func (m *Metrics) received(bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.packetsReceived++
+	m.bytesReceived += uint64(bytes)
+	m.receiveRate.add(time.Now())
+}
+
+// Record a latency measurement for a leg, e.g. "ul", "dl" or "e2e".
+func (m *Metrics) observe(leg string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latency[leg]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latency[leg] = h
+	}
+	h.observe(latency.Seconds())
+	m.lastLatency[leg] = latency.Seconds()
+}
+
+func (n *Node) writeMetrics(w io.Writer) {
+	labels := fmt.Sprintf(`node="%s",type="%s"`, n.name, n.kind)
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{%s} %v\n", name, help, name, kind, name, labels, value)
+	}
+
+	rate, _ := n.getAttr("rate")
+	paused, _ := n.getAttr("paused")
+	metric("testbed_configured_rate_hertz", "gauge", "Configured rate of the node.", rate)
+	metric("testbed_paused", "gauge", "Whether the node is paused.", paused)
+	if n.ntpClient != nil {
+		metric("testbed_ntp_offset_seconds", "gauge", "Clock offset to the NTP server.", time.Duration(n.ntpClient.GetOffset()).Seconds())
+	}
+	if n.nc != nil && n.nc.Conn != nil {
+		metric("testbed_nats_reconnects_total", "counter", "Number of reconnects to the NATS server.", n.nc.Conn.Stats().Reconnects)
+	}
+
+	m := n.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric("testbed_packets_sent_total", "counter", "Packets published by the node.", m.packetsSent)
+	metric("testbed_packets_received_total", "counter", "Packets received by the node.", m.packetsReceived)
+	metric("testbed_payload_bytes_sent_total", "counter", "Payload bytes published by the node.", m.bytesSent)
+	metric("testbed_payload_bytes_received_total", "counter", "Payload bytes received by the node.", m.bytesReceived)
+	now := time.Now()
+	metric("testbed_sent_rate_hertz", "gauge", fmt.Sprintf("Packets published per second over the last %d s.", rateWindow), m.sendRate.rate(now))
+	metric("testbed_received_rate_hertz", "gauge", fmt.Sprintf("Packets received per second over the last %d s.", rateWindow), m.receiveRate.rate(now))
+
+	legs := []string{}
+	for leg := range m.latency {
+		legs = append(legs, leg)
+	}
+	sort.Strings(legs)
+	if len(legs) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP testbed_last_latency_seconds Latency of the last packet, corrected with NTP offsets.\n# TYPE testbed_last_latency_seconds gauge\n")
+	for _, leg := range legs {
+		fmt.Fprintf(w, "testbed_last_latency_seconds{%s,leg=\"%s\"} %v\n", labels, leg, m.lastLatency[leg])
+	}
+	fmt.Fprintf(w, "# HELP testbed_latency_seconds Latency of packets, corrected with NTP offsets.\n# TYPE testbed_latency_seconds histogram\n")
+	for _, leg := range legs {
+		h := m.latency[leg]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "testbed_latency_seconds_bucket{%s,leg=\"%s\",le=\"%v\"} %d\n", labels, leg, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "testbed_latency_seconds_bucket{%s,leg=\"%s\",le=\"+Inf\"} %d\n", labels, leg, h.count)
+		fmt.Fprintf(w, "testbed_latency_seconds_sum{%s,leg=\"%s\"} %v\n", labels, leg, h.sum)
+		fmt.Fprintf(w, "testbed_latency_seconds_count{%s,leg=\"%s\"} %d\n", labels, leg, h.count)
+	}
+}
+
+// Serve the metrics on /metrics in the background.
+func (n *Node) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		var b strings.Builder
+		n.writeMetrics(&b)
+		io.WriteString(w, b.String())
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	var r rateMeter
+	start := time.Unix(1000, 0)
+	// 10 Hz for 8 s, then nothing
+	for i := 0; i < 80; i++ {
+		r.add(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	if rate := r.rate(start.Add(8 * time.Second)); rate != 10 {
+		t.Errorf("rate is %v Hz", rate)
+	}
+	if rate := r.rate(start.Add(10 * time.Second)); rate != 6 {
+		t.Errorf("rate 2 s after the last packet is %v Hz", rate)
+	}
+	if rate := r.rate(start.Add(20 * time.Second)); rate != 0 {
+		t.Errorf("rate long after the last packet is %v Hz", rate)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	node := &Node{name: "sensor", kind: "sensor", attr: map[string]int{"rate": 20}, metrics: NewMetrics()}
+	node.metrics.sent(100)
+	var b strings.Builder
+	node.writeMetrics(&b)
+	for _, line := range []string{
+		`testbed_configured_rate_hertz{node="sensor",type="sensor"} 20`,
+		`testbed_packets_sent_total{node="sensor",type="sensor"} 1`,
+		`testbed_sent_rate_hertz{node="sensor",type="sensor"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("metrics have no %s", line)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var modemFlag = flag.String("modem", "", "Where the vehicle reads the serving cell from: at:<serial device>, json:<file> or mock")
+var modemPoll = flag.Duration("modemPoll", 200*time.Millisecond, "How often should the vehicle read the serving cell?")
+
+// Source of the serving cell, e.g. a 5G modem.
+type Modem interface {
+	Read() (RadioContext, error)
+	Close() error
+}
+
+func openModem(spec string) (Modem, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "at":
+		return openATModem(arg)
+	case "json":
+		return jsonModem{file: arg}, nil
+	case "mock":
+		return &mockModem{start: time.Now()}, nil
+	}
+	return nil, fmt.Errorf("unsupported modem \"%s\"", spec)
+}
+
+// A modem with an AT command port, e.g. /dev/ttyUSB2. The serving cell is
+// read with AT+QENG="servingcell" as on Quectel RM500Q and similar modules.
+type atModem struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+func openATModem(device string) (*atModem, error) {
+	file, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &atModem{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+func (m *atModem) Close() error {
+	return m.file.Close()
+}
+
+// Send a command and collect the lines of the response up to OK.
+func (m *atModem) command(cmd string) ([]string, error) {
+	if _, err := m.file.Write([]byte(cmd + "\r")); err != nil {
+		return nil, err
+	}
+	m.file.SetReadDeadline(time.Now().Add(2 * time.Second))
+	lines := []string{}
+	for {
+		line, err := m.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "OK":
+			return lines, nil
+		case line == "ERROR" || strings.HasPrefix(line, "+CME ERROR"):
+			return nil, fmt.Errorf("%s: %s", cmd, line)
+		case len(line) != 0 && line != cmd:
+			lines = append(lines, line)
+		}
+	}
+}
+
+func (m *atModem) Read() (RadioContext, error) {
+	lines, err := m.command(`AT+QENG="servingcell"`)
+	if err != nil {
+		return RadioContext{}, err
+	}
+	return parseServingCell(lines)
+}
+
+// Parse the response to AT+QENG="servingcell". In NSA mode the LTE anchor
+// gives the cell and band, and the NR5G-NSA line the signal.
+func parseServingCell(lines []string) (RadioContext, error) {
+	var ctx RadioContext
+	found := false
+	for _, line := range lines {
+		fields := strings.Split(strings.TrimPrefix(line, "+QENG:"), ",")
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		if len(fields) > 0 && fields[0] == "servingcell" {
+			if len(fields) < 2 {
+				return ctx, fmt.Errorf("short serving cell: %s", line)
+			}
+			fields = fields[2:] // state
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		integer := func(i int) int64 {
+			if i >= len(fields) {
+				return 0
+			}
+			v, _ := strconv.ParseInt(fields[i], 10, 64)
+			return v
+		}
+		float := func(i int) float64 {
+			return float64(integer(i))
+		}
+		switch fields[0] {
+		case "LTE":
+			// LTE,is_tdd,MCC,MNC,cellID,PCID,earfcn,band,UL_bw,DL_bw,TAC,RSRP,RSRQ,RSSI,SINR,...
+			if len(fields) < 15 {
+				return ctx, fmt.Errorf("short LTE serving cell: %s", line)
+			}
+			ctx.CellID, _ = strconv.ParseInt(fields[4], 16, 64)
+			ctx.PCI = int(integer(5))
+			ctx.Band = int(integer(7))
+			ctx.RSRP, ctx.RSRQ, ctx.SINR = float(11), float(12), float(14)
+			found = true
+		case "NR5G-SA":
+			// NR5G-SA,duplex,MCC,MNC,cellID,PCID,TAC,ARFCN,band,DL_bw,RSRP,RSRQ,SINR,...
+			if len(fields) < 13 {
+				return ctx, fmt.Errorf("short NR5G-SA serving cell: %s", line)
+			}
+			ctx.CellID, _ = strconv.ParseInt(fields[4], 16, 64)
+			ctx.PCI = int(integer(5))
+			ctx.Band = int(integer(8))
+			ctx.RSRP, ctx.RSRQ, ctx.SINR = float(10), float(11), float(12)
+			found = true
+		case "NR5G-NSA":
+			// NR5G-NSA,MCC,MNC,PCID,RSRP,SINR,RSRQ,...
+			if len(fields) < 7 {
+				return ctx, fmt.Errorf("short NR5G-NSA serving cell: %s", line)
+			}
+			ctx.RSRP, ctx.SINR, ctx.RSRQ = float(4), float(5), float(6)
+		}
+	}
+	if !found {
+		return ctx, fmt.Errorf("no serving cell in response")
+	}
+	return ctx, nil
+}
+
+// A file with the serving cell as a JSON object like RadioContext, kept up
+// to date by another program, e.g. a script around qmicli or mbimcli.
+type jsonModem struct {
+	file string
+}
+
+func (m jsonModem) Read() (RadioContext, error) {
+	var ctx RadioContext
+	data, err := ioutil.ReadFile(m.file)
+	if err != nil {
+		return ctx, err
+	}
+	err = json.Unmarshal(data, &ctx)
+	return ctx, err
+}
+
+func (m jsonModem) Close() error {
+	return nil
+}
+
+// Hands over between three cells every 10 s, for testing.
+type mockModem struct {
+	start time.Time
+}
+
+func (m *mockModem) Read() (RadioContext, error) {
+	elapsed := time.Since(m.start).Seconds()
+	cell := int(elapsed/10) % 3
+	phase := math.Mod(elapsed, 10) / 10 // signal fades towards the handover
+	return RadioContext{
+		CellID: int64(0x1a2d001 + cell),
+		PCI:    100 + cell,
+		RSRP:   math.Round(-80 - 30*phase),
+		RSRQ:   math.Round(-8 - 8*phase),
+		SINR:   math.Round(25 - 25*phase),
+		Band:   78,
+	}, nil
+}
+
+func (m *mockModem) Close() error {
+	return nil
+}
+
+// Latest serving cell of a vehicle, and the handovers between them.
+type RadioMonitor struct {
+	mu        sync.Mutex
+	current   RadioContext
+	valid     bool
+	handovers []Handover
+}
+
+func (r *RadioMonitor) Current() RadioContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+func (r *RadioMonitor) Handovers() []Handover {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Handover{}, r.handovers...)
+}
+
+// Record a reading taken at `stamp` (NTP corrected), returns the handover if
+// the serving cell changed.
+func (r *RadioMonitor) update(ctx RadioContext, stamp int64, state VehicleState, lat, lon float64) *Handover {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var handover *Handover
+	if r.valid && (ctx.CellID != r.current.CellID || ctx.PCI != r.current.PCI) {
+		handover = &Handover{
+			Stamp: stamp,
+			From:  r.current, To: ctx,
+			X: state.X, Y: state.Y,
+			Latitude: lat, Longitude: lon,
+		}
+		r.handovers = append(r.handovers, *handover)
+	}
+	r.current = ctx
+	r.valid = true
+	return handover
+}
+
+// Poll the modem until the node dies. Handovers are also published on
+// "<name>.handover".
+func (n *Node) watchRadio(modem Modem, feed *VehicleFeed, interval time.Duration) {
+	defer modem.Close()
+	for n.isAlive() {
+		ctx, err := modem.Read()
+		if err != nil {
+			fmt.Printf("Modem error: %v\n", err)
+		} else {
+			state, gps := feed.get()
+			stamp := time.Now().UnixNano() + n.ntpClient.GetOffset()
+			if h := n.radio.update(ctx, stamp, state, gps.Latitude, gps.Longitude); h != nil {
+				n.nc.Publish(fmt.Sprintf("%s.handover", n.name), h)
+				fmt.Printf("Handover from cell %x (PCI %d) to %x (PCI %d)\n", h.From.CellID, h.From.PCI, h.To.CellID, h.To.PCI)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (n *Node) get_srv_handovers_cb(subj, reply string, _ GetRequest) {
+	n.nc.Publish(reply, n.radio.Handovers())
+}
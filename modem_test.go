@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseServingCell(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  RadioContext
+	}{
+		{
+			"LTE",
+			[]string{`+QENG: "servingcell","NOCONN","LTE","FDD",240,01,1A2D003,123,1300,3,5,5,1234,-95,-10,-65,15,8,-,-`},
+			RadioContext{CellID: 0x1a2d003, PCI: 123, RSRP: -95, RSRQ: -10, SINR: 15, Band: 3},
+		},
+		{
+			"SA",
+			[]string{`+QENG: "servingcell","NOCONN","NR5G-SA","TDD",240,01,E14A5F00D,501,5A,634080,78,12,-88,-11,21,1,-`},
+			RadioContext{CellID: 0xe14a5f00d, PCI: 501, RSRP: -88, RSRQ: -11, SINR: 21, Band: 78},
+		},
+		{
+			"NSA",
+			[]string{
+				`+QENG: "servingcell","NOCONN"`,
+				`+QENG: "LTE","FDD",240,01,1A2D003,123,1300,3,5,5,1234,-95,-10,-65,15,8,-,-`,
+				`+QENG: "NR5G-NSA",240,01,501,-85,20,-12,634080,78,12,1`,
+			},
+			RadioContext{CellID: 0x1a2d003, PCI: 123, RSRP: -85, RSRQ: -12, SINR: 20, Band: 3},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseServingCell(test.lines)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %+v, expected %+v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := parseServingCell([]string{`+QENG: "servingcell"`}); err == nil {
+		t.Error("garbled line parsed")
+	}
+	if _, err := parseServingCell([]string{`+QENG: "servingcell","SEARCH"`}); err == nil {
+		t.Error("no error without a serving cell")
+	}
+}
+
+func TestHandoverDetection(t *testing.T) {
+	r := &RadioMonitor{}
+	a := RadioContext{CellID: 1, PCI: 10, RSRP: -90}
+	b := RadioContext{CellID: 2, PCI: 20, RSRP: -85}
+
+	if h := r.update(a, 1, VehicleState{}, 0, 0); h != nil {
+		t.Error("handover on the first reading")
+	}
+	a.RSRP = -100
+	if h := r.update(a, 2, VehicleState{}, 0, 0); h != nil {
+		t.Error("handover without a change of cell")
+	}
+	h := r.update(b, 3, VehicleState{X: 3, Y: 4}, 59.35, 18.07)
+	if h == nil || h.Stamp != 3 || h.From != a || h.To != b || h.X != 3 || h.Latitude != 59.35 {
+		t.Fatalf("handover is %+v", h)
+	}
+	if r.Current() != b || len(r.Handovers()) != 1 {
+		t.Errorf("current cell is %+v after %d handovers", r.Current(), len(r.Handovers()))
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/goroslib/v2/pkg/msgs/sensor_msgs"
+)
+
+var motionFlag = flag.String("motion", "", "Simulate the motion of the vehicle when ROS is disabled: line, loop, or a GPX or CSV file to replay")
+var motionSpeed = flag.Float64("motionSpeed", 5, "Speed of the simulated vehicle, also for files without timestamps [m/s]")
+var motionRadius = flag.Float64("motionRadius", 20, "Radius of the simulated loop [m]")
+var motionHeading = flag.Float64("motionHeading", 0, "Direction of the simulated line, counterclockwise from east [deg]")
+var motionOrigin = flag.String("motionOrigin", "59.3500,18.0700", "Where the simulated vehicle starts (lat,lon), and x=y=0 of CSV files without GPS")
+
+const earthRadius = 6371e3 // [m]
+
+// Latest state, GPS fix and fix quality of the vehicle, written by the ROS
+// callbacks, the simulator or the GNSS receiver.
+type VehicleFeed struct {
+	mu    sync.RWMutex
+	state VehicleState
+	gps   sensor_msgs.NavSatFix
+	gnss  GNSSContext
+}
+
+func (f *VehicleFeed) setState(state VehicleState) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = state
+}
+
+func (f *VehicleFeed) setGPS(gps sensor_msgs.NavSatFix) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gps = gps
+}
+
+func (f *VehicleFeed) setGNSS(gnss GNSSContext) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gnss = gnss
+}
+
+func (f *VehicleFeed) getGNSS() GNSSContext {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.gnss
+}
+
+func (f *VehicleFeed) get() (VehicleState, sensor_msgs.NavSatFix) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.state, f.gps
+}
+
+// Where a simulated vehicle is `t` after it started, in meters from where
+// it started (x east, y north), heading [rad] and speed [m/s].
+type Motion interface {
+	At(t time.Duration) (x, y, yaw, v float64)
+}
+
+type lineMotion struct {
+	speed, heading float64
+}
+
+func (m lineMotion) At(t time.Duration) (float64, float64, float64, float64) {
+	d := m.speed * t.Seconds()
+	return d * math.Cos(m.heading), d * math.Sin(m.heading), m.heading, m.speed
+}
+
+// Counterclockwise around a circle, starting at its bottom heading east.
+type loopMotion struct {
+	speed, radius float64
+}
+
+func (m loopMotion) At(t time.Duration) (float64, float64, float64, float64) {
+	angle := -math.Pi/2 + m.speed*t.Seconds()/m.radius
+	yaw := math.Remainder(angle+math.Pi/2, 2*math.Pi)
+	return m.radius * math.Cos(angle), m.radius + m.radius*math.Sin(angle), yaw, m.speed
+}
+
+type pathPoint struct {
+	t    time.Duration // since the first point
+	x, y float64
+}
+
+// Replays a recorded path, starting over when it ends.
+type pathMotion struct {
+	points []pathPoint
+}
+
+func (m pathMotion) At(t time.Duration) (float64, float64, float64, float64) {
+	last := m.points[len(m.points)-1]
+	if last.t <= 0 {
+		return last.x, last.y, 0, 0
+	}
+	t %= last.t
+	i := sort.Search(len(m.points), func(i int) bool { return m.points[i].t > t })
+	if i == 0 {
+		i = 1
+	}
+	a, b := m.points[i-1], m.points[i]
+	dx, dy := b.x-a.x, b.y-a.y
+	dt := (b.t - a.t).Seconds()
+	f := 0.0
+	if dt > 0 {
+		f = (t - a.t).Seconds() / dt
+	}
+	v := 0.0
+	if dt > 0 {
+		v = math.Hypot(dx, dy) / dt
+	}
+	return a.x + f*dx, a.y + f*dy, math.Atan2(dy, dx), v
+}
+
+// Meters east and north of the origin, and back.
+func toLocal(lat0, lon0, lat, lon float64) (float64, float64) {
+	x := (lon - lon0) * math.Pi / 180 * earthRadius * math.Cos(lat0*math.Pi/180)
+	y := (lat - lat0) * math.Pi / 180 * earthRadius
+	return x, y
+}
+
+func toGeo(lat0, lon0, x, y float64) (float64, float64) {
+	lat := lat0 + y/earthRadius*180/math.Pi
+	lon := lon0 + x/(earthRadius*math.Cos(lat0*math.Pi/180))*180/math.Pi
+	return lat, lon
+}
+
+func parseLatLon(s string) (float64, float64, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("\"%s\" is not lat,lon", s)
+	}
+	la, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lo, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return la, lo, nil
+}
+
+// Give the points without timestamps the times of driving at `speed`.
+func timePath(points []pathPoint, timed bool, speed float64) []pathPoint {
+	if timed {
+		return points
+	}
+	for i := 1; i < len(points); i++ {
+		d := math.Hypot(points[i].x-points[i-1].x, points[i].y-points[i-1].y)
+		points[i].t = points[i-1].t + time.Duration(d/speed*float64(time.Second))
+	}
+	return points
+}
+
+type gpxFile struct {
+	Points []struct {
+		Lat  float64 `xml:"lat,attr"`
+		Lon  float64 `xml:"lon,attr"`
+		Time string  `xml:"time"`
+	} `xml:"trk>trkseg>trkpt"`
+}
+
+// The track points of a GPX file, relative to the first one.
+func loadGPX(r io.Reader, speed float64) ([]pathPoint, float64, float64, error) {
+	var gpx gpxFile
+	if err := xml.NewDecoder(r).Decode(&gpx); err != nil {
+		return nil, 0, 0, err
+	}
+	if len(gpx.Points) == 0 {
+		return nil, 0, 0, fmt.Errorf("no track points")
+	}
+	lat0, lon0 := gpx.Points[0].Lat, gpx.Points[0].Lon
+	points := []pathPoint{}
+	timed := true
+	var start time.Time
+	for i, p := range gpx.Points {
+		x, y := toLocal(lat0, lon0, p.Lat, p.Lon)
+		point := pathPoint{x: x, y: y}
+		stamp, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+		if err != nil {
+			timed = false
+		} else if i == 0 {
+			start = stamp
+		} else {
+			point.t = stamp.Sub(start)
+		}
+		points = append(points, point)
+	}
+	return timePath(points, timed, speed), lat0, lon0, nil
+}
+
+// A path from a CSV with lat and lon, or x and y, columns, such as a packet
+// log. Times are taken from a t4 [ns] or time [s] column if there is one.
+func loadPathCSV(r io.Reader, speed, lat0, lon0 float64) ([]pathPoint, float64, float64, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(names ...string) int {
+		for _, name := range names {
+			if i, ok := columns[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	latCol, lonCol := column("lat", "latitude"), column("lon", "longitude")
+	xCol, yCol := column("x"), column("y")
+	nsCol, secCol := column("t4"), column("time")
+	if (latCol < 0 || lonCol < 0) && (xCol < 0 || yCol < 0) {
+		return nil, 0, 0, fmt.Errorf("needs lat and lon, or x and y, columns")
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	value := func(record []string, i int) float64 {
+		if i < 0 || i >= len(record) {
+			return 0
+		}
+		v, _ := strconv.ParseFloat(record[i], 64)
+		return v
+	}
+
+	// GPS if the file has any, x and y otherwise
+	useGPS := false
+	for _, record := range records {
+		if value(record, latCol) != 0 || value(record, lonCol) != 0 {
+			useGPS = true
+			break
+		}
+	}
+
+	points := []pathPoint{}
+	timed := nsCol >= 0 || secCol >= 0
+	var start float64 // [s]
+	for _, record := range records {
+		point := pathPoint{}
+		if useGPS {
+			lat, lon := value(record, latCol), value(record, lonCol)
+			if lat == 0 && lon == 0 {
+				continue // no fix
+			}
+			if len(points) == 0 {
+				lat0, lon0 = lat, lon
+			}
+			point.x, point.y = toLocal(lat0, lon0, lat, lon)
+		} else {
+			point.x, point.y = value(record, xCol), value(record, yCol)
+		}
+		if timed {
+			stamp := value(record, secCol)
+			if nsCol >= 0 {
+				stamp = value(record, nsCol) / 1e9
+			}
+			if len(points) == 0 {
+				start = stamp
+			}
+			point.t = time.Duration((stamp - start) * float64(time.Second))
+		}
+		points = append(points, point)
+	}
+	if len(points) == 0 {
+		return nil, 0, 0, fmt.Errorf("no positions")
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].t < points[j].t })
+	return timePath(points, timed, speed), lat0, lon0, nil
+}
+
+// The motion for -motion, and the latitude and longitude where it starts.
+func newMotion(spec string) (Motion, float64, float64, error) {
+	lat0, lon0, err := parseLatLon(*motionOrigin)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	switch spec {
+	case "line":
+		return lineMotion{speed: *motionSpeed, heading: *motionHeading * math.Pi / 180}, lat0, lon0, nil
+	case "loop":
+		return loopMotion{speed: *motionSpeed, radius: *motionRadius}, lat0, lon0, nil
+	}
+
+	file, err := os.Open(spec)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+	var points []pathPoint
+	if strings.HasSuffix(strings.ToLower(spec), ".gpx") {
+		points, lat0, lon0, err = loadGPX(file, *motionSpeed)
+	} else {
+		points, lat0, lon0, err = loadPathCSV(file, *motionSpeed, lat0, lon0)
+	}
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("%s: %v", spec, err)
+	}
+	return pathMotion{points: points}, lat0, lon0, nil
+}
+
+// Write the simulated state and GPS fix to the feed, like the ROS topics
+// would, until the node dies.
+func (n *Node) simulateMotion(feed *VehicleFeed, motion Motion, lat0, lon0 float64) {
+	start := time.Now()
+	for n.isAlive() {
+		now := time.Now()
+		x, y, yaw, v := motion.At(now.Sub(start))
+		state := VehicleState{X: x, Y: y, Yaw: yaw, V: float32(v)}
+		state.Header.Stamp = now
+		feed.setState(state)
+
+		gps := sensor_msgs.NavSatFix{}
+		gps.Header.Stamp = now
+		gps.Latitude, gps.Longitude = toGeo(lat0, lon0, x, y)
+		feed.setGPS(gps)
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
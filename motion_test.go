@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestLoopMotion(t *testing.T) {
+	m := loopMotion{speed: 2, radius: 10}
+	lap := time.Duration(2 * math.Pi * m.radius / m.speed * float64(time.Second))
+	if x, y, yaw, _ := m.At(0); !approx(x, 0) || !approx(y, 0) || !approx(yaw, 0) {
+		t.Errorf("starts at %v,%v heading %v", x, y, yaw)
+	}
+	if x, y, yaw, _ := m.At(lap / 4); !approx(x, 10) || !approx(y, 10) || !approx(yaw, math.Pi/2) {
+		t.Errorf("after a quarter lap at %v,%v heading %v", x, y, yaw)
+	}
+	if x, y, _, _ := m.At(lap); !approx(x, 0) || !approx(y, 0) {
+		t.Errorf("after a lap at %v,%v", x, y)
+	}
+}
+
+func TestGPXMotion(t *testing.T) {
+	gpx := `<gpx><trk><trkseg>
+		<trkpt lat="59.35" lon="18.07"><time>2023-06-01T10:00:00Z</time></trkpt>
+		<trkpt lat="59.3501" lon="18.07"><time>2023-06-01T10:00:10Z</time></trkpt>
+	</trkseg></trk></gpx>`
+	points, lat0, lon0, err := loadGPX(strings.NewReader(gpx), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat0 != 59.35 || lon0 != 18.07 || len(points) != 2 || points[1].t != 10*time.Second {
+		t.Fatalf("loaded %v from %v,%v", points, lat0, lon0)
+	}
+
+	// 0.0001 deg north is about 11 m, driven in 10 s
+	x, y, yaw, v := pathMotion{points}.At(5 * time.Second)
+	if !approx(x, 0) || math.Abs(y-5.56) > 0.01 || !approx(yaw, math.Pi/2) || math.Abs(v-1.11) > 0.01 {
+		t.Errorf("halfway at %v,%v heading %v at %v m/s", x, y, yaw, v)
+	}
+	if lat, lon := toGeo(lat0, lon0, x, y); !approx(lat, 59.35005) || !approx(lon, 18.07) {
+		t.Errorf("halfway at %v,%v", lat, lon)
+	}
+}
+
+func TestCSVMotion(t *testing.T) {
+	csv := "x,y\n0,0\n3,4\n3,0\n"
+	points, _, _, err := loadPathCSV(strings.NewReader(csv), 5, 59.35, 18.07)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || points[1].t != time.Second || points[2].t != 1800*time.Millisecond {
+		t.Fatalf("loaded %v", points)
+	}
+
+	// Starts over when the path ends
+	if x, y, _, _ := (pathMotion{points}).At(2300 * time.Millisecond); !approx(x, 1.5) || !approx(y, 2) {
+		t.Errorf("after 2.3 s at %v,%v", x, y)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -10,21 +12,37 @@ import (
 
 type Node struct {
 	name      string
+	kind      string
 	attr      map[string]int
+	attrMu    sync.RWMutex
 	main      func(*Node)
 	logs      []Packet
+	logsMu    sync.Mutex // received packets are added while the log is read or replaced
 	nc        *nats.EncodedConn
 	ntpClient *NTPClient
+	metrics   *Metrics
+	stats     *RollingStats
+	allow     []string
+	policy    Policy
+	audit     *AuditLog
+	link      *Link
+	radio     *RadioMonitor
+	payload   *PayloadGenerator // of sensors and RSUs, nil for random data
+	stream    *FrameSource      // of sensors and RSUs, nil for single packets
 }
 
-func NewNode(name string, nc *nats.EncodedConn, ntpClient *NTPClient, main func(*Node)) *Node {
+func NewNode(name string, kind string, nc *nats.EncodedConn, ntpClient *NTPClient, main func(*Node)) *Node {
 
 	node := &Node{
 		name:      name,
+		kind:      kind,
 		attr:      map[string]int{"rate": 1, "paused": 1, "alive": 1},
 		main:      main,
 		nc:        nc,
 		ntpClient: ntpClient,
+		metrics:   NewMetrics(),
+		policy:    defaultPolicy(),
+		audit:     &AuditLog{},
 	}
 
 	// SETUP own setters and getters
@@ -32,11 +50,38 @@ func NewNode(name string, nc *nats.EncodedConn, ntpClient *NTPClient, main func(
 	nc.Subscribe(fmt.Sprintf("%s.get.log", name), node.get_srv_log_cb)
 	nc.Subscribe(fmt.Sprintf("%s.set", name), node.set_srv_cb)
 	nc.Subscribe(fmt.Sprintf("%s.set.log", name), node.set_srv_log_cb)
+	nc.Subscribe(fmt.Sprintf("%s.get.audit", name), node.get_srv_audit_cb)
 	return node
 }
 
+// Get the value of an attribute, and whether it exists.
+func (n *Node) getAttr(name string) (int, bool) {
+	n.attrMu.RLock()
+	defer n.attrMu.RUnlock()
+	val, ok := n.attr[name]
+	return val, ok
+}
+
+// Set the value of an attribute, creating it if it does not exist.
+func (n *Node) setAttr(name string, value int) {
+	n.attrMu.Lock()
+	defer n.attrMu.Unlock()
+	n.attr[name] = value
+}
+
+// Copy all attributes, e.g. to report them in a heartbeat.
+func (n *Node) attrs() map[string]int {
+	n.attrMu.RLock()
+	defer n.attrMu.RUnlock()
+	params := make(map[string]int, len(n.attr))
+	for k, v := range n.attr {
+		params[k] = v
+	}
+	return params
+}
+
 func (n *Node) get_srv_cb(subj, reply string, msg GetRequest) {
-	if val, ok := n.attr[msg.Name]; ok {
+	if val, ok := n.getAttr(msg.Name); ok {
 		resp := &GetResponse{Success: true, Data: val}
 		err := n.nc.Publish(reply, resp)
 		if err != nil {
@@ -54,15 +99,29 @@ func (n *Node) get_srv_cb(subj, reply string, msg GetRequest) {
 }
 
 func (n *Node) get_srv_log_cb(subj, reply string, _ SetRequest) {
+	n.logsMu.Lock()
+	logs := append([]Packet{}, n.logs...)
+	n.logsMu.Unlock()
 	timeNow := time.Now().Format("060102_1504")
 	fileName := fmt.Sprintf("%s.csv", timeNow)
-	save(n.logs, fileName)
-	n.nc.Publish(reply, n.logs)
+	save(logs, fileName)
+	n.nc.Publish(reply, logs)
+}
+
+func (n *Node) get_srv_audit_cb(subj, reply string, _ GetRequest) {
+	n.nc.Publish(reply, n.audit.Entries())
 }
 
 func (n *Node) set_srv_cb(subj, reply string, msg SetRequest) {
-	if _, ok := n.attr[msg.Name]; ok {
-		n.attr[msg.Name] = msg.Data
+	if old, ok := n.getAttr(msg.Name); ok {
+		if err := n.authorize(msg.Author, msg.Name); err != nil {
+			n.audit.record(msg.Author, msg.Name, old, msg.Data, err)
+			n.nc.Publish(reply, &SetResponse{Success: false, Reason: err.Error()})
+			fmt.Println("Refused setting", msg.Name, "to", msg.Data, "by", msg.Author)
+			return
+		}
+		n.setAttr(msg.Name, msg.Data)
+		n.audit.record(msg.Author, msg.Name, old, msg.Data, nil)
 		n.nc.Publish(reply, &SetResponse{Success: true})
 		fmt.Println("Setting", msg.Name, "to", msg.Data, "by", msg.Author)
 	} else {
@@ -71,8 +130,20 @@ func (n *Node) set_srv_cb(subj, reply string, msg SetRequest) {
 	}
 }
 
-func (n *Node) set_srv_log_cb(subj, reply string, msg []Packet) {
-	n.logs = msg
+func (n *Node) set_srv_log_cb(subj, reply string, msg SetLogRequest) {
+	n.logsMu.Lock()
+	defer n.logsMu.Unlock()
+	if err := n.authorize(msg.Author, "log"); err != nil {
+		n.audit.record(msg.Author, "log", len(n.logs), len(msg.Data), err)
+		n.nc.Publish(reply, &SetResponse{Success: false, Reason: err.Error()})
+		fmt.Println("Refused setting log by", msg.Author)
+		return
+	}
+	n.audit.record(msg.Author, "log", len(n.logs), len(msg.Data), nil)
+	n.logs = msg.Data
+	if n.stats != nil {
+		n.stats.reset()
+	}
 	n.nc.Publish(reply, &SetResponse{Success: true})
 }
 
@@ -133,37 +204,64 @@ func (n *Node) remote_set(remote_name string, attr_name string, value int) (SetR
 	if err != nil {
 		return SetResponse{}, err
 	}
+	if !resp.Success {
+		return resp, errors.New(resp.Reason)
+	}
 	return resp, nil
 }
 
 func (n *Node) remote_set_log(remote_name string, value []Packet) (SetResponse, error) {
+	req := &SetLogRequest{Author: n.name, Data: value}
 	var resp SetResponse
-	err := n.nc.Request(fmt.Sprintf("%s.set.log", remote_name), value, &resp, time.Second)
+	err := n.nc.Request(fmt.Sprintf("%s.set.log", remote_name), req, &resp, time.Second)
 	if err != nil {
 		return SetResponse{}, err
 	}
+	if !resp.Success {
+		return resp, errors.New(resp.Reason)
+	}
+	return resp, nil
+}
+
+func (n *Node) remote_get_audit(remote_name string) ([]AuditEntry, error) {
+	req := &GetRequest{Author: n.name}
+	var resp []AuditEntry
+	err := n.nc.Request(fmt.Sprintf("%s.get.audit", remote_name), req, &resp, time.Second)
+	if err != nil {
+		return []AuditEntry{}, err
+	}
+	return resp, nil
+}
+
+func (n *Node) remote_get_handovers(remote_name string) ([]Handover, error) {
+	req := &GetRequest{Author: n.name}
+	var resp []Handover
+	err := n.nc.Request(fmt.Sprintf("%s.get.handovers", remote_name), req, &resp, time.Second)
+	if err != nil {
+		return []Handover{}, err
+	}
 	return resp, nil
 }
 
 func (n *Node) isAlive() bool {
-	val, _ := n.attr["alive"]
+	val, _ := n.getAttr("alive")
 	return val != 0
 }
 
 func (n *Node) isPaused() bool {
-	val, _ := n.attr["paused"]
+	val, _ := n.getAttr("paused")
 	return val != 0
 }
 
 func (n *Node) run() {
 	for {
-		if val, _ := n.attr["alive"]; val == 0 {
+		if !n.isAlive() {
 			break
 		}
-		if val, _ := n.attr["paused"]; val == 0 {
+		if !n.isPaused() {
 			n.main(n)
 		}
-		rate, _ := n.attr["rate"]
+		rate, _ := n.getAttr("rate")
 		time.Sleep(time.Duration(1e9 / rate))
 	}
 }
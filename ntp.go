@@ -3,6 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/beevik/ntp"
@@ -10,7 +13,8 @@ import (
 
 type NTPClient struct {
 	Url  string
-	Resp ntp.Response
+	Resp ntp.Response // the last one, read it with response() while QueryLoop runs
+	mu   sync.Mutex
 }
 
 func ConnectNTP(url string) (*NTPClient, error) {
@@ -20,27 +24,55 @@ func ConnectNTP(url string) (*NTPClient, error) {
 	return &NTPClient{Url: url}, nil
 }
 
+// A copy of the last response.
+func (c *NTPClient) response() ntp.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Resp
+}
+
 func (c *NTPClient) GetOffset() int64 {
-	return c.Resp.ClockOffset.Nanoseconds()
+	resp := c.response()
+	return resp.ClockOffset.Nanoseconds()
 }
 
+func (c *NTPClient) Status() NTPStatus {
+	resp := c.response()
+	return NTPStatus{
+		Server:  c.Url,
+		Offset:  resp.ClockOffset.Nanoseconds(),
+		RTT:     resp.RTT.Nanoseconds(),
+		Stratum: resp.Stratum,
+		Valid:   !resp.Time.IsZero() && resp.Validate() == nil,
+	}
+}
+
+// The URL may include a port, e.g. for a local test server.
 func (c *NTPClient) SingleQuery() {
-	resp, err := ntp.Query(c.Url)
+	host, opts := c.Url, ntp.QueryOptions{}
+	if h, p, err := net.SplitHostPort(c.Url); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			host, opts.Port = h, port
+		}
+	}
+	resp, err := ntp.QueryWithOptions(host, opts)
 	if err != nil {
 		fmt.Printf("NTP error: %v\n", err)
 	} else {
+		c.mu.Lock()
 		c.Resp = *resp
+		c.mu.Unlock()
 	}
 }
 
 var ntpMaxPoll = flag.Duration("ntpMaxPoll", 250*time.Millisecond, "NTP Max Poll Interval")
 
 func (c *NTPClient) QueryLoop(pred func(ntp.Response) bool) {
-	for pred(c.Resp) {
+	for pred(c.response()) {
 		c.SingleQuery()
 		sleepDur := *ntpMaxPoll
 		// Poll is the maximum interval between successive NTP polling messages. It is not relevant for simple NTP clients like this one.
-		if c.Resp.Poll < sleepDur {
+		if c.response().Poll < sleepDur {
 			// sleepDur = c.Resp.Poll
 		}
 		time.Sleep(sleepDur)
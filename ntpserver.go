@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var ntpListenAddr = flag.String("ntpListen", ":123", "Where should the ntp node answer NTP queries?")
+var ntpOffsetFlag = flag.Duration("ntpOffset", 0, "Offset of the clock the ntp node serves, e.g. 50ms")
+var ntpDriftFlag = flag.Int("ntpDrift", 0, "How fast the offset of the ntp node grows [ppm]")
+var ntpJitterFlag = flag.Duration("ntpJitter", 0, "Random error added to each time the ntp node serves")
+var ntpDelayFlag = flag.Duration("ntpDelay", 0, "Extra round trip time of replies from the ntp node")
+var ntpOutageFlag = flag.String("ntpOutage", "", "Periodic outages of the ntp node as duration/period, e.g. 10s/1m")
+
+// Seconds between the NTP era (1900) and the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+func ntpTimestamp(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// How the clock of an NTP server is wrong, and how it misbehaves.
+type NTPServerConfig struct {
+	Offset      time.Duration
+	Drift       float64 // [ppm]
+	Jitter      time.Duration
+	Delay       time.Duration // round trip, half of it each way
+	Down        bool
+	OutageFor   time.Duration
+	OutageEvery time.Duration
+}
+
+// Answers NTP queries with a simulated clock, for testing.
+type NTPServer struct {
+	mu     sync.Mutex
+	conn   net.PacketConn
+	start  time.Time
+	config NTPServerConfig
+	rng    *rand.Rand
+}
+
+func NewNTPServer(addr string, config NTPServerConfig) (*NTPServer, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &NTPServer{conn: conn, start: time.Now(), config: config, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	go s.serve()
+	return s, nil
+}
+
+// Where the server listens, e.g. to use as -ntp.
+func (s *NTPServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *NTPServer) Close() error {
+	return s.conn.Close()
+}
+
+func (s *NTPServer) Configure(config NTPServerConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// How far the simulated clock is off at `now`, and whether the server is
+// down. Jitter is drawn anew for every call.
+func (s *NTPServer) offset(now time.Time) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.config
+	elapsed := now.Sub(s.start)
+	if c.Down || (c.OutageEvery > 0 && elapsed%c.OutageEvery < c.OutageFor) {
+		return 0, true
+	}
+	offset := c.Offset + time.Duration(float64(elapsed)*c.Drift*1e-6)
+	if c.Jitter > 0 {
+		offset += time.Duration((2*s.rng.Float64() - 1) * float64(c.Jitter))
+	}
+	return offset, false
+}
+
+func (s *NTPServer) delay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config.Delay / 2
+}
+
+func (s *NTPServer) serve() {
+	buf := make([]byte, 48)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 48 {
+			continue
+		}
+		query := append([]byte{}, buf[:48]...)
+		go s.reply(query, addr)
+	}
+}
+
+func (s *NTPServer) reply(query []byte, addr net.Addr) {
+	time.Sleep(s.delay()) // on the way to the server
+	now := time.Now()
+	offset, down := s.offset(now)
+	if down {
+		return
+	}
+
+	resp := make([]byte, 48)
+	resp[0] = 0<<6 | 4<<3 | 4 // no leap second, version 4, server
+	resp[1] = 1               // stratum
+	resp[2] = query[2]        // poll
+	resp[3] = 0xec            // precision, 2^-20 s
+	copy(resp[12:16], "SIM")
+	binary.BigEndian.PutUint64(resp[16:], ntpTimestamp(now.Add(offset)))
+	copy(resp[24:32], query[40:48]) // origin is the client's transmit time
+	binary.BigEndian.PutUint64(resp[32:], ntpTimestamp(now.Add(offset)))
+	binary.BigEndian.PutUint64(resp[40:], ntpTimestamp(time.Now().Add(offset)))
+
+	time.Sleep(s.delay()) // on the way back
+	s.conn.WriteTo(resp, addr)
+}
+
+// Parse -ntpOutage, e.g. "10s/1m" is down for 10 s every minute.
+func parseOutage(spec string) (duration time.Duration, period time.Duration, err error) {
+	if len(spec) == 0 {
+		return 0, 0, nil
+	}
+	d, p, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("outage \"%s\" is not duration/period", spec)
+	}
+	if duration, err = time.ParseDuration(d); err != nil {
+		return 0, 0, err
+	}
+	if period, err = time.ParseDuration(p); err != nil {
+		return 0, 0, err
+	}
+	return duration, period, nil
+}
+
+// A node serving NTP on -ntpListen. Its clock can be changed while it runs
+// with the NTP_OFFSET [ms], NTP_DRIFT [ppm], NTP_JITTER [ms], NTP_DELAY [ms]
+// and NTP_DOWN (1 to stop answering) attributes.
+func newNTPServer(name string, nc *nats.EncodedConn) *Node {
+	outageFor, outageEvery, err := parseOutage(*ntpOutageFlag)
+	if err != nil {
+		panic(err)
+	}
+	config := func(node *Node) NTPServerConfig {
+		get := func(name string) int {
+			val, _ := node.getAttr(name)
+			return val
+		}
+		return NTPServerConfig{
+			Offset:      time.Duration(get("NTP_OFFSET")) * time.Millisecond,
+			Drift:       float64(get("NTP_DRIFT")),
+			Jitter:      time.Duration(get("NTP_JITTER")) * time.Millisecond,
+			Delay:       time.Duration(get("NTP_DELAY")) * time.Millisecond,
+			Down:        get("NTP_DOWN") != 0,
+			OutageFor:   outageFor,
+			OutageEvery: outageEvery,
+		}
+	}
+
+	var srv *NTPServer
+	node := NewNode(name, "ntp", nc, nil, func(node *Node) {
+		srv.Configure(config(node))
+	})
+	node.setAttr("NTP_OFFSET", int(ntpOffsetFlag.Milliseconds()))
+	node.setAttr("NTP_DRIFT", *ntpDriftFlag)
+	node.setAttr("NTP_JITTER", int(ntpJitterFlag.Milliseconds()))
+	node.setAttr("NTP_DELAY", int(ntpDelayFlag.Milliseconds()))
+	node.setAttr("NTP_DOWN", 0)
+	node.setAttr("rate", 10)
+	node.setAttr("paused", 0)
+
+	srv, err = NewNTPServer(*ntpListenAddr, config(node))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Serving NTP on %s\n", srv.Addr())
+	return node
+}
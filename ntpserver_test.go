@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func startNTPServer(t *testing.T, config NTPServerConfig) *NTPServer {
+	srv, err := NewNTPServer("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func query(t *testing.T, srv *NTPServer) *NTPClient {
+	c, err := ConnectNTP(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SingleQuery()
+	if c.Resp.Time.IsZero() {
+		t.Fatal("no response")
+	}
+	if err := c.Resp.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func near(t *testing.T, what string, got, want, tolerance time.Duration) {
+	t.Helper()
+	if got < want-tolerance || got > want+tolerance {
+		t.Errorf("%s is %v, expected %v ± %v", what, got, want, tolerance)
+	}
+}
+
+func TestNTPServerOffset(t *testing.T) {
+	srv := startNTPServer(t, NTPServerConfig{Offset: 250 * time.Millisecond})
+	c := query(t, srv)
+	near(t, "offset", c.Resp.ClockOffset, 250*time.Millisecond, 20*time.Millisecond)
+	if !c.Status().Valid {
+		t.Error("status is not valid")
+	}
+
+	srv.Configure(NTPServerConfig{Offset: -1 * time.Second})
+	c.SingleQuery()
+	near(t, "offset", c.Resp.ClockOffset, -1*time.Second, 20*time.Millisecond)
+}
+
+func TestNTPServerDrift(t *testing.T) {
+	srv := startNTPServer(t, NTPServerConfig{Drift: 100_000}) // 100 ms per s
+	time.Sleep(500 * time.Millisecond)
+	c := query(t, srv)
+	near(t, "offset", c.Resp.ClockOffset, 50*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestNTPServerDelay(t *testing.T) {
+	srv := startNTPServer(t, NTPServerConfig{Offset: 100 * time.Millisecond, Delay: 300 * time.Millisecond})
+	c := query(t, srv)
+	near(t, "RTT", c.Resp.RTT, 300*time.Millisecond, 30*time.Millisecond)
+	near(t, "offset", c.Resp.ClockOffset, 100*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestNTPServerJitter(t *testing.T) {
+	srv := startNTPServer(t, NTPServerConfig{Jitter: 50 * time.Millisecond})
+	c := query(t, srv)
+	min, max := c.Resp.ClockOffset, c.Resp.ClockOffset
+	for i := 0; i < 20; i++ {
+		c.SingleQuery()
+		if c.Resp.ClockOffset < min {
+			min = c.Resp.ClockOffset
+		}
+		if c.Resp.ClockOffset > max {
+			max = c.Resp.ClockOffset
+		}
+	}
+	if max-min < 10*time.Millisecond || min < -60*time.Millisecond || max > 60*time.Millisecond {
+		t.Errorf("offsets range from %v to %v, expected a spread within ±50ms", min, max)
+	}
+}
+
+// The client keeps the last response while the server does not answer.
+func TestNTPServerOutage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the NTP timeout")
+	}
+	srv := startNTPServer(t, NTPServerConfig{Offset: 100 * time.Millisecond})
+	c := query(t, srv)
+	last := c.Resp.Time
+
+	srv.Configure(NTPServerConfig{Offset: 200 * time.Millisecond, OutageFor: time.Hour, OutageEvery: 2 * time.Hour})
+	c.SingleQuery()
+	if c.Resp.Time != last {
+		t.Error("response changed during the outage")
+	}
+
+	srv.Configure(NTPServerConfig{Offset: 200 * time.Millisecond})
+	c.SingleQuery()
+	near(t, "offset", c.Resp.ClockOffset, 200*time.Millisecond, 20*time.Millisecond)
+}
+
+// At the default -ntpMaxPoll, as nodes of other tests may still be reading it.
+func TestQueryLoop(t *testing.T) {
+	srv := startNTPServer(t, NTPServerConfig{Offset: 30 * time.Millisecond})
+	c, err := ConnectNTP(srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		queries := 0
+		c.QueryLoop(func(r ntp.Response) bool {
+			if queries == 3 {
+				srv.Configure(NTPServerConfig{Offset: -30 * time.Millisecond})
+			}
+			queries++
+			return r.ClockOffset > -20*time.Millisecond
+		})
+		close(done)
+	}()
+	timeout := time.After(5 * time.Second)
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-timeout:
+			t.Fatal("query loop did not follow the new offset")
+		default:
+			c.Status() // like heartbeats do, while the loop queries
+			time.Sleep(time.Millisecond)
+		}
+	}
+	near(t, "offset", c.Resp.ClockOffset, -30*time.Millisecond, 10*time.Millisecond)
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/goroslib/v2/pkg/msgs/sensor_msgs"
+)
+
+var payloadFlag = flag.String("payload", "random", "What a sensor or RSU sends: random bytes of DATA_SIZE, or a cam, denm, cpm or bsm message")
+var encodingFlag = flag.String("encoding", "uper", "Encoding of -payload messages: uper (ASN.1 unaligned PER) or json")
+var signedFlag = flag.Bool("signed", false, "Wrap -payload messages in a security envelope of realistic size")
+var payloadFrom = flag.String("payloadFrom", "", "Vehicle whose position fills -payload messages, simulated with -motion (or a loop) if empty")
+var cpmObjects = flag.Float64("cpmObjects", 8, "Mean number of objects in a CPM")
+
+// Reads or writes the fields of a message in ASN.1 unaligned PER. The
+// same description of a message is used for both, see `perMessage`.
+type perCodec interface {
+	integer(v *int64, lo, hi int64) // constrained whole number
+	boolean(v *bool)
+	octets(v *[]byte, n int) // fixed size octet string
+	size(n *int, lo, hi int) // of a SEQUENCE OF
+}
+
+type perMessage interface {
+	per(c perCodec)
+}
+
+// Bits needed for a constrained whole number in [lo, hi].
+func perBits(lo, hi int64) int {
+	n := 0
+	for r := uint64(hi - lo); r > 0; r >>= 1 {
+		n++
+	}
+	return n
+}
+
+type perWriter struct {
+	buf  []byte
+	bits int
+}
+
+func (w *perWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.buf[w.bits/8] |= 0x80 >> uint(w.bits%8)
+		}
+		w.bits++
+	}
+}
+
+func (w *perWriter) integer(v *int64, lo, hi int64) {
+	value := *v
+	if value < lo {
+		value = lo
+	} else if value > hi {
+		value = hi
+	}
+	w.write(uint64(value-lo), perBits(lo, hi))
+}
+
+func (w *perWriter) boolean(v *bool) {
+	if *v {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+}
+
+func (w *perWriter) octets(v *[]byte, n int) {
+	for i := 0; i < n; i++ {
+		b := byte(0)
+		if i < len(*v) {
+			b = (*v)[i]
+		}
+		w.write(uint64(b), 8)
+	}
+}
+
+func (w *perWriter) size(n *int, lo, hi int) {
+	v := int64(*n)
+	w.integer(&v, int64(lo), int64(hi))
+}
+
+type perReader struct {
+	buf  []byte
+	bits int
+	err  error
+}
+
+func (r *perReader) read(n int) uint64 {
+	if r.bits+n > 8*len(r.buf) {
+		if r.err == nil {
+			r.err = fmt.Errorf("message ends after %d bits", 8*len(r.buf))
+		}
+		return 0
+	}
+	v := uint64(0)
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint64(r.buf[r.bits/8]>>(7-uint(r.bits%8))&1)
+		r.bits++
+	}
+	return v
+}
+
+func (r *perReader) integer(v *int64, lo, hi int64) {
+	*v = lo + int64(r.read(perBits(lo, hi)))
+	if *v > hi && r.err == nil {
+		r.err = fmt.Errorf("%d is out of range [%d, %d]", *v, lo, hi)
+	}
+}
+
+func (r *perReader) boolean(v *bool) {
+	*v = r.read(1) == 1
+}
+
+func (r *perReader) octets(v *[]byte, n int) {
+	*v = make([]byte, n)
+	for i := range *v {
+		(*v)[i] = byte(r.read(8))
+	}
+}
+
+func (r *perReader) size(n *int, lo, hi int) {
+	v := int64(0)
+	r.integer(&v, int64(lo), int64(hi))
+	*n = int(v)
+}
+
+func encodeUPER(m perMessage) []byte {
+	w := &perWriter{}
+	m.per(w)
+	return w.buf
+}
+
+func decodeUPER(data []byte, m perMessage) error {
+	r := &perReader{buf: data}
+	m.per(r)
+	if r.err == nil && (len(data)*8-r.bits) >= 8 {
+		r.err = fmt.Errorf("%d bytes left after the message", len(data)-(r.bits+7)/8)
+	}
+	return r.err
+}
+
+// Fields as in ETSI TS 102 894-2 (CDD), with their ranges.
+type ItsPduHeader struct {
+	ProtocolVersion int64 `json:"protocolVersion"`
+	MessageID       int64 `json:"messageID"`
+	StationID       int64 `json:"stationID"`
+}
+
+func (h *ItsPduHeader) per(c perCodec) {
+	c.integer(&h.ProtocolVersion, 0, 255)
+	c.integer(&h.MessageID, 0, 255)
+	c.integer(&h.StationID, 0, 4294967295)
+}
+
+type ReferencePosition struct {
+	Latitude           int64 `json:"latitude"`  // [0.1 µdeg]
+	Longitude          int64 `json:"longitude"` // [0.1 µdeg]
+	SemiMajor          int64 `json:"semiMajorConfidence"`
+	SemiMinor          int64 `json:"semiMinorConfidence"`
+	Orientation        int64 `json:"semiMajorOrientation"`
+	Altitude           int64 `json:"altitudeValue"` // [cm]
+	AltitudeConfidence int64 `json:"altitudeConfidence"`
+}
+
+func (p *ReferencePosition) per(c perCodec) {
+	c.integer(&p.Latitude, -900000000, 900000001)
+	c.integer(&p.Longitude, -1800000000, 1800000001)
+	c.integer(&p.SemiMajor, 0, 4095)
+	c.integer(&p.SemiMinor, 0, 4095)
+	c.integer(&p.Orientation, 0, 3601)
+	c.integer(&p.Altitude, -100000, 800001)
+	c.integer(&p.AltitudeConfidence, 0, 15)
+}
+
+type PathPoint struct {
+	DeltaLatitude  int64 `json:"deltaLatitude"`
+	DeltaLongitude int64 `json:"deltaLongitude"`
+	DeltaAltitude  int64 `json:"deltaAltitude"`
+	DeltaTime      int64 `json:"pathDeltaTime"` // [10 ms]
+}
+
+func (p *PathPoint) per(c perCodec) {
+	c.integer(&p.DeltaLatitude, -131071, 131072)
+	c.integer(&p.DeltaLongitude, -131071, 131072)
+	c.integer(&p.DeltaAltitude, -12700, 12800)
+	c.integer(&p.DeltaTime, 1, 65535)
+}
+
+func perPath(c perCodec, path *[]PathPoint, max int) {
+	n := len(*path)
+	c.size(&n, 0, max)
+	if len(*path) != n {
+		*path = make([]PathPoint, n)
+	}
+	for i := range *path {
+		(*path)[i].per(c)
+	}
+}
+
+// Cooperative Awareness Message (EN 302 637-2).
+type CAM struct {
+	Header                   ItsPduHeader      `json:"header"`
+	GenerationDeltaTime      int64             `json:"generationDeltaTime"`
+	StationType              int64             `json:"stationType"`
+	Position                 ReferencePosition `json:"referencePosition"`
+	Heading                  int64             `json:"heading"` // [0.1 deg] from north
+	HeadingConfidence        int64             `json:"headingConfidence"`
+	Speed                    int64             `json:"speed"` // [cm/s]
+	SpeedConfidence          int64             `json:"speedConfidence"`
+	DriveDirection           int64             `json:"driveDirection"`
+	VehicleLength            int64             `json:"vehicleLength"`
+	VehicleWidth             int64             `json:"vehicleWidth"`
+	LongitudinalAcceleration int64             `json:"longitudinalAcceleration"`
+	Curvature                int64             `json:"curvature"`
+	YawRate                  int64             `json:"yawRate"`
+	LowFrequency             *CAMLowFrequency  `json:"lowFrequencyContainer,omitempty"`
+}
+
+type CAMLowFrequency struct {
+	VehicleRole    int64       `json:"vehicleRole"`
+	ExteriorLights int64       `json:"exteriorLights"`
+	PathHistory    []PathPoint `json:"pathHistory"`
+}
+
+func (m *CAM) per(c perCodec) {
+	m.Header.per(c)
+	c.integer(&m.GenerationDeltaTime, 0, 65535)
+	c.integer(&m.StationType, 0, 255)
+	m.Position.per(c)
+	c.integer(&m.Heading, 0, 3601)
+	c.integer(&m.HeadingConfidence, 1, 127)
+	c.integer(&m.Speed, 0, 16383)
+	c.integer(&m.SpeedConfidence, 1, 127)
+	c.integer(&m.DriveDirection, 0, 2)
+	c.integer(&m.VehicleLength, 1, 1023)
+	c.integer(&m.VehicleWidth, 1, 62)
+	c.integer(&m.LongitudinalAcceleration, -160, 161)
+	c.integer(&m.Curvature, -1023, 1023)
+	c.integer(&m.YawRate, -32766, 32767)
+	present := m.LowFrequency != nil
+	c.boolean(&present)
+	if present {
+		if m.LowFrequency == nil {
+			m.LowFrequency = &CAMLowFrequency{}
+		}
+		c.integer(&m.LowFrequency.VehicleRole, 0, 15)
+		c.integer(&m.LowFrequency.ExteriorLights, 0, 255)
+		perPath(c, &m.LowFrequency.PathHistory, 40)
+	}
+}
+
+// Decentralized Environmental Notification Message (EN 302 637-3).
+type DENM struct {
+	Header             ItsPduHeader      `json:"header"`
+	OriginatingStation int64             `json:"originatingStationID"`
+	SequenceNumber     int64             `json:"sequenceNumber"`
+	DetectionTime      int64             `json:"detectionTime"` // [ms] since 2004
+	ReferenceTime      int64             `json:"referenceTime"`
+	EventPosition      ReferencePosition `json:"eventPosition"`
+	RelevanceDistance  int64             `json:"relevanceDistance"`
+	ValidityDuration   int64             `json:"validityDuration"` // [s]
+	StationType        int64             `json:"stationType"`
+	InformationQuality int64             `json:"informationQuality"`
+	CauseCode          int64             `json:"causeCode"`
+	SubCauseCode       int64             `json:"subCauseCode"`
+	Traces             [][]PathPoint     `json:"traces"`
+}
+
+func (m *DENM) per(c perCodec) {
+	m.Header.per(c)
+	c.integer(&m.OriginatingStation, 0, 4294967295)
+	c.integer(&m.SequenceNumber, 0, 65535)
+	c.integer(&m.DetectionTime, 0, 4398046511103)
+	c.integer(&m.ReferenceTime, 0, 4398046511103)
+	m.EventPosition.per(c)
+	c.integer(&m.RelevanceDistance, 0, 7)
+	c.integer(&m.ValidityDuration, 0, 86400)
+	c.integer(&m.StationType, 0, 255)
+	c.integer(&m.InformationQuality, 0, 7)
+	c.integer(&m.CauseCode, 0, 255)
+	c.integer(&m.SubCauseCode, 0, 255)
+	n := len(m.Traces)
+	c.size(&n, 1, 7)
+	if len(m.Traces) != n {
+		m.Traces = make([][]PathPoint, n)
+	}
+	for i := range m.Traces {
+		perPath(c, &m.Traces[i], 40)
+	}
+}
+
+// Collective Perception Message (TS 103 324).
+type CPM struct {
+	Header              ItsPduHeader      `json:"header"`
+	GenerationDeltaTime int64             `json:"generationDeltaTime"`
+	StationType         int64             `json:"stationType"`
+	Position            ReferencePosition `json:"referencePosition"`
+	Sensors             []CPMSensor       `json:"sensorInformationContainer"`
+	Objects             []PerceivedObject `json:"perceivedObjectContainer"`
+}
+
+type CPMSensor struct {
+	ID    int64 `json:"sensorID"`
+	Type  int64 `json:"sensorType"`
+	Range int64 `json:"detectionRange"` // [0.1 m]
+}
+
+type PerceivedObject struct {
+	ID               int64 `json:"objectID"`
+	MeasurementDelta int64 `json:"measurementDeltaTime"` // [ms]
+	X                int64 `json:"xDistance"`            // [cm]
+	Y                int64 `json:"yDistance"`
+	XConfidence      int64 `json:"xConfidence"`
+	YConfidence      int64 `json:"yConfidence"`
+	XSpeed           int64 `json:"xSpeed"` // [cm/s]
+	YSpeed           int64 `json:"ySpeed"`
+	SpeedConfidence  int64 `json:"speedConfidence"`
+	Age              int64 `json:"objectAge"` // [ms]
+	Class            int64 `json:"classification"`
+	ClassConfidence  int64 `json:"classificationConfidence"`
+}
+
+func (m *CPM) per(c perCodec) {
+	m.Header.per(c)
+	c.integer(&m.GenerationDeltaTime, 0, 65535)
+	c.integer(&m.StationType, 0, 255)
+	m.Position.per(c)
+	n := len(m.Sensors)
+	c.size(&n, 1, 128)
+	if len(m.Sensors) != n {
+		m.Sensors = make([]CPMSensor, n)
+	}
+	for i := range m.Sensors {
+		s := &m.Sensors[i]
+		c.integer(&s.ID, 0, 255)
+		c.integer(&s.Type, 0, 31)
+		c.integer(&s.Range, 0, 10000)
+	}
+	n = len(m.Objects)
+	c.size(&n, 0, 255)
+	if len(m.Objects) != n {
+		m.Objects = make([]PerceivedObject, n)
+	}
+	for i := range m.Objects {
+		o := &m.Objects[i]
+		c.integer(&o.ID, 0, 255)
+		c.integer(&o.MeasurementDelta, -1500, 1500)
+		c.integer(&o.X, -132768, 132767)
+		c.integer(&o.Y, -132768, 132767)
+		c.integer(&o.XConfidence, 1, 4096)
+		c.integer(&o.YConfidence, 1, 4096)
+		c.integer(&o.XSpeed, -16383, 16383)
+		c.integer(&o.YSpeed, -16383, 16383)
+		c.integer(&o.SpeedConfidence, 1, 127)
+		c.integer(&o.Age, 0, 1500)
+		c.integer(&o.Class, 0, 255)
+		c.integer(&o.ClassConfidence, 0, 101)
+	}
+}
+
+// Basic Safety Message (SAE J2735), in a MessageFrame.
+type BSM struct {
+	MessageID     int64       `json:"messageId"`
+	MsgCnt        int64       `json:"msgCnt"`
+	ID            []byte      `json:"id"`
+	SecMark       int64       `json:"secMark"` // [ms] in the minute
+	Latitude      int64       `json:"lat"`     // [0.1 µdeg]
+	Longitude     int64       `json:"long"`
+	Elevation     int64       `json:"elev"` // [10 cm]
+	SemiMajor     int64       `json:"semiMajor"`
+	SemiMinor     int64       `json:"semiMinor"`
+	Orientation   int64       `json:"orientation"`
+	Transmission  int64       `json:"transmission"`
+	Speed         int64       `json:"speed"`   // [0.02 m/s]
+	Heading       int64       `json:"heading"` // [0.0125 deg]
+	SteeringAngle int64       `json:"angle"`
+	AccelLong     int64       `json:"accelLong"`
+	AccelLat      int64       `json:"accelLat"`
+	AccelVert     int64       `json:"accelVert"`
+	YawRate       int64       `json:"yawRate"`
+	Brakes        int64       `json:"brakes"`
+	Width         int64       `json:"width"`                 // [cm]
+	Length        int64       `json:"length"`                // [cm]
+	PathHistory   []PathPoint `json:"pathHistory,omitempty"` // part II
+}
+
+func (m *BSM) per(c perCodec) {
+	c.integer(&m.MessageID, 0, 32767)
+	c.integer(&m.MsgCnt, 0, 127)
+	c.octets(&m.ID, 4)
+	c.integer(&m.SecMark, 0, 65535)
+	c.integer(&m.Latitude, -900000000, 900000001)
+	c.integer(&m.Longitude, -1799999999, 1800000001)
+	c.integer(&m.Elevation, -4096, 61439)
+	c.integer(&m.SemiMajor, 0, 255)
+	c.integer(&m.SemiMinor, 0, 255)
+	c.integer(&m.Orientation, 0, 65535)
+	c.integer(&m.Transmission, 0, 7)
+	c.integer(&m.Speed, 0, 8191)
+	c.integer(&m.Heading, 0, 28800)
+	c.integer(&m.SteeringAngle, -126, 127)
+	c.integer(&m.AccelLong, -2000, 2001)
+	c.integer(&m.AccelLat, -2000, 2001)
+	c.integer(&m.AccelVert, -127, 127)
+	c.integer(&m.YawRate, -32767, 32767)
+	c.integer(&m.Brakes, 0, 2047)
+	c.integer(&m.Width, 0, 1023)
+	c.integer(&m.Length, 0, 4095)
+	present := len(m.PathHistory) != 0
+	c.boolean(&present)
+	if present {
+		perPath(c, &m.PathHistory, 23)
+	}
+}
+
+// An empty message of a kind.
+func newV2XMessage(kind string) (perMessage, error) {
+	switch kind {
+	case "cam":
+		return &CAM{}, nil
+	case "denm":
+		return &DENM{}, nil
+	case "cpm":
+		return &CPM{}, nil
+	case "bsm":
+		return &BSM{}, nil
+	}
+	return nil, fmt.Errorf("unsupported payload \"%s\"", kind)
+}
+
+// A signed message is wrapped as: protocol version 3, length of the message
+// (2 bytes), the message, and a trailer with the signer and signature.
+const securedVersion = 3
+
+// The signer is a full certificate once a second, a digest otherwise.
+func secure(message []byte, certificate bool, rng *rand.Rand) []byte {
+	signer := 8
+	if certificate {
+		signer = 129
+	}
+	envelope := []byte{securedVersion, 0, 0}
+	binary.BigEndian.PutUint16(envelope[1:], uint16(len(message)))
+	envelope = append(envelope, message...)
+	trailer := make([]byte, 12+signer+67) // header info, signer, ECDSA signature
+	rng.Read(trailer)
+	return append(envelope, trailer...)
+}
+
+// Decode a payload of the kind and encoding in `label`, e.g. cam/uper, like
+// a receiver would.
+func decodePayload(label string, data []byte) (perMessage, error) {
+	kind, encoding, _ := strings.Cut(label, "/")
+	m, err := newV2XMessage(kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 3 && data[0] == securedVersion {
+		n := int(binary.BigEndian.Uint16(data[1:3]))
+		if 3+n > len(data) {
+			return nil, fmt.Errorf("secured message of %d bytes is shorter than %d", len(data), 3+n)
+		}
+		data = data[3 : 3+n]
+	}
+	if encoding == "json" {
+		return m, json.Unmarshal(data, m)
+	}
+	return m, decodeUPER(data, m)
+}
+
+// Builds messages of one kind from the state of a vehicle.
+type PayloadGenerator struct {
+	kind, encoding string
+	feed           *VehicleFeed
+	rng            *rand.Rand
+	stationID      int64
+	count          int64
+	history        []pathSample // newest last
+	lastLow        time.Time    // of the last CAM low frequency container, or BSM part II
+	lastCert       time.Time
+}
+
+type pathSample struct {
+	stamp    time.Time
+	lat, lon float64
+}
+
+func NewPayloadGenerator(kind, encoding string, feed *VehicleFeed) (*PayloadGenerator, error) {
+	if _, err := newV2XMessage(kind); err != nil {
+		return nil, err
+	}
+	if encoding != "uper" && encoding != "json" {
+		return nil, fmt.Errorf("unsupported encoding \"%s\"", encoding)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &PayloadGenerator{kind: kind, encoding: encoding, feed: feed, rng: rng, stationID: rng.Int63n(1 << 32)}, nil
+}
+
+func (g *PayloadGenerator) label() string {
+	return g.kind + "/" + g.encoding
+}
+
+// Path history since the last `max` samples a second apart, newest first.
+func (g *PayloadGenerator) path(lat, lon float64, now time.Time, max int) []PathPoint {
+	points := []PathPoint{}
+	prevLat, prevLon, prevTime := lat, lon, now
+	for i := len(g.history) - 1; i >= 0 && len(points) < max; i-- {
+		s := g.history[i]
+		points = append(points, PathPoint{
+			DeltaLatitude:  int64(math.Round((s.lat - prevLat) * 1e7)),
+			DeltaLongitude: int64(math.Round((s.lon - prevLon) * 1e7)),
+			DeltaTime:      int64(math.Max(1, math.Round(prevTime.Sub(s.stamp).Seconds()*100))),
+		})
+		prevLat, prevLon, prevTime = s.lat, s.lon, s.stamp
+	}
+	return points
+}
+
+func (g *PayloadGenerator) position(lat, lon float64) ReferencePosition {
+	return ReferencePosition{
+		Latitude:           int64(math.Round(lat * 1e7)),
+		Longitude:          int64(math.Round(lon * 1e7)),
+		SemiMajor:          int64(100 + g.rng.Intn(200)),
+		SemiMinor:          int64(50 + g.rng.Intn(100)),
+		Orientation:        int64(g.rng.Intn(3600)),
+		Altitude:           int64(3000 + g.rng.Intn(100)),
+		AltitudeConfidence: 5,
+	}
+}
+
+// The next message, encoded and signed if -signed.
+func (g *PayloadGenerator) next() ([]byte, error) {
+	now := time.Now()
+	state, gps := g.feed.get()
+	lat, lon := gps.Latitude, gps.Longitude
+	heading := math.Mod(450-state.Yaw*180/math.Pi, 360) // [deg] clockwise from north
+	speed := math.Abs(float64(state.V))
+	g.count++
+
+	var m perMessage
+	header := func(id int64) ItsPduHeader {
+		return ItsPduHeader{ProtocolVersion: 2, MessageID: id, StationID: g.stationID}
+	}
+	lowFrequency := now.Sub(g.lastLow) >= 500*time.Millisecond
+	if lowFrequency {
+		g.lastLow = now
+	}
+	switch g.kind {
+	case "cam":
+		cam := &CAM{
+			Header:                   header(2),
+			GenerationDeltaTime:      now.UnixMilli() % 65536,
+			StationType:              5, // passenger car
+			Position:                 g.position(lat, lon),
+			Heading:                  int64(heading * 10),
+			HeadingConfidence:        int64(1 + g.rng.Intn(20)),
+			Speed:                    int64(speed * 100),
+			SpeedConfidence:          int64(1 + g.rng.Intn(20)),
+			VehicleLength:            45,
+			VehicleWidth:             18,
+			LongitudinalAcceleration: int64(g.rng.Intn(21) - 10),
+			YawRate:                  int64(g.rng.Intn(201) - 100),
+		}
+		if lowFrequency {
+			cam.LowFrequency = &CAMLowFrequency{ExteriorLights: int64(g.rng.Intn(4)), PathHistory: g.path(lat, lon, now, 40)}
+		}
+		m = cam
+	case "denm":
+		traces := [][]PathPoint{g.path(lat, lon, now, 40)}
+		for i := g.rng.Intn(3); i > 0; i-- {
+			traces = append(traces, g.path(lat, lon, now, 10+g.rng.Intn(30)))
+		}
+		detection := now.Sub(time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC)).Milliseconds()
+		m = &DENM{
+			Header:             header(1),
+			OriginatingStation: g.stationID,
+			SequenceNumber:     g.count % 65536,
+			DetectionTime:      detection,
+			ReferenceTime:      detection,
+			EventPosition:      g.position(lat, lon),
+			RelevanceDistance:  int64(g.rng.Intn(8)),
+			ValidityDuration:   600,
+			StationType:        5,
+			InformationQuality: int64(1 + g.rng.Intn(7)),
+			CauseCode:          []int64{1, 2, 3, 9, 12, 94, 97, 99}[g.rng.Intn(8)],
+			SubCauseCode:       int64(g.rng.Intn(10)),
+			Traces:             traces,
+		}
+	case "cpm":
+		cpm := &CPM{
+			Header:              header(14),
+			GenerationDeltaTime: now.UnixMilli() % 65536,
+			StationType:         5,
+			Position:            g.position(lat, lon),
+		}
+		for i := 1 + g.rng.Intn(4); i > 0; i-- {
+			cpm.Sensors = append(cpm.Sensors, CPMSensor{ID: int64(len(cpm.Sensors)), Type: int64(1 + g.rng.Intn(5)), Range: int64(500 + g.rng.Intn(2000))})
+		}
+		objects := int(math.Min(255, math.Round(g.rng.ExpFloat64()**cpmObjects)))
+		for i := 0; i < objects; i++ {
+			cpm.Objects = append(cpm.Objects, PerceivedObject{
+				ID:               int64(i),
+				MeasurementDelta: int64(g.rng.Intn(100)),
+				X:                int64(g.rng.NormFloat64() * 3000),
+				Y:                int64(g.rng.NormFloat64() * 3000),
+				XConfidence:      int64(1 + g.rng.Intn(200)),
+				YConfidence:      int64(1 + g.rng.Intn(200)),
+				XSpeed:           int64(g.rng.NormFloat64() * 500),
+				YSpeed:           int64(g.rng.NormFloat64() * 500),
+				SpeedConfidence:  int64(1 + g.rng.Intn(50)),
+				Age:              int64(g.rng.Intn(1500)),
+				Class:            int64(g.rng.Intn(12)),
+				ClassConfidence:  int64(50 + g.rng.Intn(51)),
+			})
+		}
+		m = cpm
+	case "bsm":
+		bsm := &BSM{
+			MessageID:    20,
+			MsgCnt:       g.count % 128,
+			ID:           binary.BigEndian.AppendUint32(nil, uint32(g.stationID)),
+			SecMark:      int64(now.Second()*1000 + now.Nanosecond()/1e6),
+			Latitude:     int64(math.Round(lat * 1e7)),
+			Longitude:    int64(math.Round(lon * 1e7)),
+			Elevation:    300,
+			SemiMajor:    int64(20 + g.rng.Intn(50)),
+			SemiMinor:    int64(10 + g.rng.Intn(30)),
+			Orientation:  int64(g.rng.Intn(65536)),
+			Transmission: 2, // forward gears
+			Speed:        int64(speed / 0.02),
+			Heading:      int64(heading / 0.0125),
+			YawRate:      int64(g.rng.Intn(201) - 100),
+			Width:        180,
+			Length:       450,
+		}
+		if lowFrequency {
+			bsm.PathHistory = g.path(lat, lon, now, 23)
+		}
+		m = bsm
+	}
+
+	if len(g.history) == 0 || now.Sub(g.history[len(g.history)-1].stamp) >= time.Second {
+		g.history = append(g.history, pathSample{stamp: now, lat: lat, lon: lon})
+		if len(g.history) > 40 {
+			g.history = g.history[1:]
+		}
+	}
+
+	var data []byte
+	if g.encoding == "json" {
+		var err error
+		if data, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	} else {
+		data = encodeUPER(m)
+	}
+	if *signedFlag {
+		certificate := now.Sub(g.lastCert) >= time.Second
+		if certificate {
+			g.lastCert = now
+		}
+		data = secure(data, certificate, g.rng)
+	}
+	return data, nil
+}
+
+// Feed the state of the vehicle in -payloadFrom, or simulate one, for the
+// node's payload generator.
+func (n *Node) payloadFeed() *VehicleFeed {
+	feed := &VehicleFeed{}
+	if len(*payloadFrom) != 0 {
+		var last Position
+		n.nc.Subscribe(fmt.Sprintf("%s.position", *payloadFrom), func(p *Position) {
+			state := VehicleState{X: p.X, Y: p.Y}
+			if last.Stamp != 0 && p.Stamp > last.Stamp {
+				dx, dy := p.X-last.X, p.Y-last.Y
+				state.V = float32(math.Hypot(dx, dy) / time.Duration(p.Stamp-last.Stamp).Seconds())
+				state.Yaw = math.Atan2(dy, dx)
+			}
+			last = *p
+			feed.setState(state)
+			feed.setGPS(sensor_msgs.NavSatFix{Latitude: p.Latitude, Longitude: p.Longitude})
+		})
+		return feed
+	}
+	spec := *motionFlag
+	if len(spec) == 0 {
+		spec = "loop"
+	}
+	motion, lat0, lon0, err := newMotion(spec)
+	if err != nil {
+		panic(err)
+	}
+	go n.simulateMotion(feed, motion, lat0, lon0)
+	return feed
+}
+
+// Make the node send -payload messages instead of random data.
+func (n *Node) usePayload() {
+	if *payloadFlag == "random" {
+		return
+	}
+	generator, err := NewPayloadGenerator(*payloadFlag, *encodingFlag, n.payloadFeed())
+	if err != nil {
+		panic(err)
+	}
+	n.payload = generator
+}
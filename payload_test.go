@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/bluenviron/goroslib/v2/pkg/msgs/sensor_msgs"
+)
+
+func TestPayloadRoundTrip(t *testing.T) {
+	feed := &VehicleFeed{}
+	feed.setState(VehicleState{Yaw: 1, V: 12})
+	feed.setGPS(sensor_msgs.NavSatFix{Latitude: 59.35, Longitude: 18.07})
+
+	// Plausible sizes of UPER messages, without security. A CPM may have no
+	// objects.
+	sizes := map[string][2]int{"cam": {30, 400}, "denm": {40, 1000}, "cpm": {25, 5000}, "bsm": {30, 300}}
+	for _, kind := range []string{"cam", "denm", "cpm", "bsm"} {
+		for _, encoding := range []string{"uper", "json"} {
+			g, err := NewPayloadGenerator(kind, encoding, feed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 5; i++ {
+				data, err := g.next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				m, err := decodePayload(g.label(), data)
+				if err != nil {
+					t.Fatalf("%s: %v", g.label(), err)
+				}
+				var again []byte
+				if encoding == "json" {
+					again, _ = json.Marshal(m)
+				} else {
+					again = encodeUPER(m)
+					if len(data) < sizes[kind][0] || len(data) > sizes[kind][1] {
+						t.Errorf("%s is %d B", g.label(), len(data))
+					}
+				}
+				if !bytes.Equal(data, again) {
+					t.Errorf("%s changed after decoding", g.label())
+				}
+			}
+		}
+	}
+	if _, err := NewPayloadGenerator("spat", "uper", feed); err == nil {
+		t.Error("unknown payload accepted")
+	}
+}
+
+func TestPayloadSigned(t *testing.T) {
+	feed := &VehicleFeed{}
+	g, _ := NewPayloadGenerator("cam", "uper", feed)
+	message := encodeUPER(&CAM{Header: ItsPduHeader{ProtocolVersion: 2, MessageID: 2, StationID: 7}})
+	first := secure(message, true, g.rng)
+	second := secure(message, false, g.rng)
+	if len(first)-len(second) != 121 {
+		t.Errorf("certificate adds %d B", len(first)-len(second))
+	}
+	m, err := decodePayload("cam/uper", first)
+	if err != nil || m.(*CAM).Header.StationID != 7 {
+		t.Errorf("decoded %+v, %v", m, err)
+	}
+	if _, err := decodePayload("cam/uper", message[:len(message)-2]); err == nil {
+		t.Error("truncated message decoded")
+	}
+}
+
+func TestPerBits(t *testing.T) {
+	for _, c := range []struct {
+		lo, hi int64
+		bits   int
+	}{{0, 0, 0}, {0, 1, 1}, {0, 255, 8}, {1, 127, 7}, {-900000000, 900000001, 31}, {0, 4294967295, 32}} {
+		if bits := perBits(c.lo, c.hi); bits != c.bits {
+			t.Errorf("[%d, %d] needs %d bits, not %d", c.lo, c.hi, c.bits, bits)
+		}
+	}
+}
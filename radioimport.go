@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const importUsage = `Usage: wp3go import [-window d] [-offset d] [-tz zone] <measurements> <log dir or file>...
+
+Joins a time-stamped modem measurement export (CSV, JSON array or JSON
+lines) with packet logs, by the NTP corrected time each packet arrived at
+the vehicle. Each packet gets the last measurement before it, if it is at
+most -window old. The result is written next to each log as <name>_radio.<ext>.
+For a suite directory, all cases in its manifest are joined and the
+manifest is updated with the new files, and with handovers if it had none.
+`
+
+// Column names, in lower case, that modem exports use for each field.
+var measurementColumns = map[string][]string{
+	"stamp":   {"time", "timestamp", "stamp", "datetime", "date_time", "utc"},
+	"cell_id": {"cell_id", "cellid", "cell id", "ci", "eci", "nci", "ecgi"},
+	"pci":     {"pci", "pcid", "phys_cell_id"},
+	"rsrp":    {"rsrp", "ss_rsrp", "nr_rsrp", "lte_rsrp"},
+	"rsrq":    {"rsrq", "ss_rsrq", "nr_rsrq", "lte_rsrq"},
+	"sinr":    {"sinr", "ss_sinr", "nr_sinr", "lte_sinr", "snr", "rssnr"},
+	"band":    {"band", "freq_band", "band_ind"},
+}
+
+type measurement struct {
+	stamp int64 // [ns]
+	radio RadioContext
+}
+
+// Parse a timestamp as a Unix time in s, ms, µs or ns (by magnitude), or as
+// a date and time. Times without a zone are in `loc`. Integers are exact,
+// those in ns being beyond what a float64 holds.
+func parseStamp(s string, loc *time.Location) (int64, error) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		switch {
+		case v > 1e17:
+			return v, nil
+		case v > 1e14:
+			return v * 1e3, nil
+		case v > 1e11:
+			return v * 1e6, nil
+		default:
+			return v * 1e9, nil
+		}
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		switch {
+		case v > 1e17:
+			return int64(v), nil
+		case v > 1e14:
+			return int64(v * 1e3), nil
+		case v > 1e11:
+			return int64(v * 1e6), nil
+		default:
+			return int64(v * 1e9), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UnixNano(), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006/01/02 15:04:05.999999999"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t.UnixNano(), nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized time \"%s\"", s)
+}
+
+// Build a measurement from named values, cell IDs may be hexadecimal.
+func newMeasurement(value func(field string) (string, bool), loc *time.Location) (measurement, error) {
+	var m measurement
+	s, ok := value("stamp")
+	if !ok {
+		return m, fmt.Errorf("no time")
+	}
+	stamp, err := parseStamp(s, loc)
+	if err != nil {
+		return m, err
+	}
+	m.stamp = stamp
+	number := func(field string) float64 {
+		s, _ := value(field)
+		v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return v
+	}
+	if s, ok := value("cell_id"); ok {
+		s = strings.TrimSpace(s)
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			id, err = strconv.ParseInt(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 64)
+		}
+		if err != nil {
+			return m, fmt.Errorf("cell ID \"%s\": %v", s, err)
+		}
+		m.radio.CellID = id
+	}
+	m.radio.PCI = int(number("pci"))
+	m.radio.RSRP = number("rsrp")
+	m.radio.RSRQ = number("rsrq")
+	m.radio.SINR = number("sinr")
+	m.radio.Band = int(number("band"))
+	return m, nil
+}
+
+func lookup(row map[string]string, field string) (string, bool) {
+	for _, name := range measurementColumns[field] {
+		if v, ok := row[name]; ok && len(strings.TrimSpace(v)) != 0 {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Read a modem export, sorted by time.
+func loadMeasurements(file string, loc *time.Location) ([]measurement, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rows := []map[string]string{}
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		rows, err = jsonRows(trimmed)
+	} else {
+		rows, err = csvRows(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	measurements := []measurement{}
+	for i, row := range rows {
+		m, err := newMeasurement(func(field string) (string, bool) { return lookup(row, field) }, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: row %d: %v", file, i+1, err)
+		}
+		measurements = append(measurements, m)
+	}
+	sort.SliceStable(measurements, func(i, j int) bool { return measurements[i].stamp < measurements[j].stamp })
+	return measurements, nil
+}
+
+func csvRows(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	rows := []map[string]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := map[string]string{}
+		for i, name := range header {
+			if i < len(record) {
+				row[strings.ToLower(strings.TrimSpace(name))] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Objects of a JSON array or of JSON lines, with their values as strings.
+func jsonRows(data []byte) ([]map[string]string, error) {
+	objects := []map[string]interface{}{}
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			object := map[string]interface{}{}
+			if err := json.Unmarshal(line, &object); err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	rows := []map[string]string{}
+	for _, object := range objects {
+		row := map[string]string{}
+		for k, v := range object {
+			switch v := v.(type) {
+			case float64:
+				row[strings.ToLower(k)] = strconv.FormatFloat(v, 'f', -1, 64)
+			case nil:
+			default:
+				row[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Give each packet the last measurement at most `window` before it arrived,
+// `offset` is added to the measurement times. Returns how many matched.
+func joinRadio(log []Packet, measurements []measurement, window, offset time.Duration) int {
+	matched := 0
+	for i := range log {
+		p := &log[i]
+		arrived := p.T4 + p.E4
+		j := sort.Search(len(measurements), func(j int) bool { return measurements[j].stamp+int64(offset) > arrived }) - 1
+		if j < 0 || arrived-(measurements[j].stamp+int64(offset)) > int64(window) {
+			continue
+		}
+		p.RadioContext = measurements[j].radio
+		matched++
+	}
+	return matched
+}
+
+// Changes of serving cell between consecutive packets with radio context.
+func radioHandovers(log []Packet) []Handover {
+	sorted := append([]Packet{}, log...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].T4+sorted[i].E4 < sorted[j].T4+sorted[j].E4 })
+	handovers := []Handover{}
+	var last *Packet
+	for i := range sorted {
+		p := &sorted[i]
+		if p.CellID == 0 && p.PCI == 0 {
+			continue
+		}
+		if last != nil && (p.CellID != last.CellID || p.PCI != last.PCI) {
+			handovers = append(handovers, Handover{
+				Stamp: p.T4 + p.E4,
+				From:  last.RadioContext, To: p.RadioContext,
+				X: p.X, Y: p.Y,
+				Latitude: p.Latitude, Longitude: p.Longitude,
+			})
+		}
+		last = p
+	}
+	return handovers
+}
+
+// e.g. logs/x/y__TC1000.csv.gz -> logs/x/y__TC1000_radio.csv.gz
+func radioFileName(filename string) string {
+	ext := "." + logFormat(filename)
+	if !strings.HasSuffix(filename, ext) {
+		ext = path.Ext(filename)
+	}
+	return strings.TrimSuffix(filename, ext) + "_radio" + ext
+}
+
+// Join a packet log with the measurements, returns the new file.
+func importRadioLog(file string, measurements []measurement, window, offset time.Duration) (string, []Packet, error) {
+	log, err := loadLog(file)
+	if err != nil {
+		return "", nil, err
+	}
+	matched := joinRadio(log, measurements, window, offset)
+	out := radioFileName(file)
+	if err := writeLog(log, out); err != nil {
+		return "", nil, err
+	}
+	fmt.Printf("%s: %d/%d packets have radio measurements\n", out, matched, len(log))
+	return out, log, nil
+}
+
+// Import modem measurements into packet logs. Returns the exit code.
+func importRadio(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), importUsage) }
+	window := fs.Duration("window", 2*time.Second, "How old may the measurement of a packet be?")
+	offset := fs.Duration("offset", 0, "Added to the measurement times, e.g. if the modem clock is off.")
+	tz := fs.String("tz", "Local", "Time zone of measurement times without one.")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 1
+	}
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return fail(err)
+	}
+	measurements, err := loadMeasurements(fs.Arg(0), loc)
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, target := range fs.Args()[1:] {
+		info, err := os.Stat(target)
+		if err != nil {
+			return fail(err)
+		}
+		if !info.IsDir() {
+			if _, _, err := importRadioLog(target, measurements, *window, *offset); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		manifest, err := loadManifest(target)
+		if err != nil {
+			return fail(err)
+		}
+		for i := range manifest.Cases {
+			result := &manifest.Cases[i]
+			out, log, err := importRadioLog(path.Join(target, result.Filename), measurements, *window, *offset)
+			if err != nil {
+				return fail(err)
+			}
+			result.RadioFile = path.Base(out)
+			if len(result.Handovers) == 0 {
+				result.Handovers = radioHandovers(log)
+			}
+		}
+		if err := manifest.save(target); err != nil {
+			return fail(err)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseStamp(t *testing.T) {
+	want := time.Date(2023, 6, 1, 10, 0, 0, 500_000_000, time.UTC).UnixNano()
+	for _, s := range []string{
+		"1685613600.5",
+		"1685613600500",
+		"1685613600500000",
+		"1685613600500000000",
+		"2023-06-01T10:00:00.5Z",
+		"2023-06-01T12:00:00.5+02:00",
+		"2023-06-01 10:00:00.500",
+	} {
+		got, err := parseStamp(s, time.UTC)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if got != want {
+			t.Errorf("%s is %d, expected %d", s, got, want)
+		}
+	}
+	if got, _ := parseStamp("1685613600123456789", time.UTC); got != 1685613600123456789 {
+		t.Errorf("ns timestamp is %d", got)
+	}
+}
+
+func TestImportRadio(t *testing.T) {
+	dir := t.TempDir()
+	export := path.Join(dir, "modem.csv")
+	os.WriteFile(export, []byte("Timestamp,Cell ID,PCI,RSRP,RSRQ,SINR,Band\n"+
+		"1685613600.0,1A2D001,100,-90,-10,20,78\n"+
+		"1685613601.0,1A2D002,101,-85,-9,22,78\n"), 0644)
+	measurements, err := loadMeasurements(export, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measurements) != 2 || measurements[1].radio.CellID != 0x1a2d002 {
+		t.Fatalf("loaded %+v", measurements)
+	}
+
+	packet := func(seq int64, arrived float64) Packet {
+		p := Packet{}
+		p.Header.Seq = seq
+		p.T4 = int64(arrived * 1e9)
+		return p
+	}
+	log := []Packet{
+		packet(0, 1685613599.5), // before the first measurement
+		packet(1, 1685613600.5),
+		packet(2, 1685613601.5),
+		packet(3, 1685613604.0), // too long after the last one
+	}
+	if matched := joinRadio(log, measurements, 2*time.Second, 0); matched != 2 {
+		t.Errorf("%d packets matched, expected 2", matched)
+	}
+	if log[0].CellID != 0 || log[1].PCI != 100 || log[2].PCI != 101 || log[3].CellID != 0 {
+		t.Errorf("joined %+v", log)
+	}
+
+	handovers := radioHandovers(log)
+	if len(handovers) != 1 || handovers[0].From.PCI != 100 || handovers[0].To.PCI != 101 || handovers[0].Stamp != log[2].T4 {
+		t.Errorf("handovers are %+v", handovers)
+	}
+
+	if name := radioFileName("logs/x/y__TC1000.csv.gz"); name != "logs/x/y__TC1000_radio.csv.gz" {
+		t.Errorf("radio file is %s", name)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var replayFlag = flag.String("replay", "", "Packet log from a past run whose delays and losses are applied to outgoing packets")
+var replayByFlag = flag.String("replayBy", "seq", "How packets are matched to the replayed log: seq, or position of the vehicle")
+var replayRadius = flag.Float64("replayRadius", 10, "With -replayBy position, draw from recorded packets within this distance [m]")
+var replayFrom = flag.String("replayFrom", "vehicle", "With -replayBy position, the vehicle whose position is followed")
+var positionInterval = flag.Duration("position", 100*time.Millisecond, "How often should vehicles publish their position? (0 to disable)")
+
+// A packet of the replayed log, or a gap in its sequence numbers.
+type replayEntry struct {
+	lost     bool
+	ul, dl   time.Duration
+	position Position
+}
+
+// Applies the uplink or downlink delays and the losses of a recorded run. A
+// lost packet was never seen by the server, so it is lost on the uplink.
+type Replay struct {
+	mu       sync.Mutex
+	leg      string // "ul" or "dl"
+	entries  []replayEntry
+	position *Position // latest of the vehicle, nil until one arrives
+	radius   float64
+}
+
+// Entries for the sequence numbers from the first to the last packet of the
+// log. Lost packets get the position of the packet before them.
+func replayEntries(log []Packet) []replayEntry {
+	if len(log) == 0 {
+		return nil
+	}
+	sorted := append([]Packet{}, log...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Header.Seq < sorted[j].Header.Seq })
+
+	first := sorted[0].Header.Seq
+	entries := make([]replayEntry, sorted[len(sorted)-1].Header.Seq-first+1)
+	for i := range entries {
+		entries[i].lost = true
+	}
+	for i := range sorted {
+		p := &sorted[i]
+		e := &entries[p.Header.Seq-first]
+		if !e.lost {
+			continue // duplicate
+		}
+		*e = replayEntry{
+			ul:       p.Uplink(),
+			dl:       p.Downlink(),
+			position: Position{X: p.X, Y: p.Y, Latitude: p.Latitude, Longitude: p.Longitude},
+		}
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].lost {
+			entries[i].position = entries[i-1].position
+		}
+	}
+	return entries
+}
+
+func NewReplay(file string, leg string, byPosition bool) (*Replay, error) {
+	log, err := loadLog(file)
+	if err != nil {
+		return nil, err
+	}
+	entries := replayEntries(log)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no packets to replay", file)
+	}
+	r := &Replay{leg: leg, entries: entries, radius: -1}
+	if byPosition {
+		r.radius = *replayRadius
+	}
+	return r, nil
+}
+
+// Distance in meters, using GPS if both positions have it, otherwise x and y.
+func distance(a, b Position) float64 {
+	if (a.Latitude != 0 || a.Longitude != 0) && (b.Latitude != 0 || b.Longitude != 0) {
+		lat := (a.Latitude + b.Latitude) / 2 * math.Pi / 180
+		dx := (b.Longitude - a.Longitude) * math.Pi / 180 * math.Cos(lat) * earthRadius
+		dy := (b.Latitude - a.Latitude) * math.Pi / 180 * earthRadius
+		return math.Hypot(dx, dy)
+	}
+	return math.Hypot(b.X-a.X, b.Y-a.Y)
+}
+
+func (r *Replay) setPosition(pos Position) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.position = &pos
+}
+
+// An entry recorded within the radius of `pos`, or the nearest one if there
+// is none. Which one depends on the packet's `seq`, so both legs of a packet
+// replay the same recording while the vehicle stays put. The downlink only
+// takes received ones, a lost packet never reaching it.
+func (r *Replay) near(pos Position, seq int64) replayEntry {
+	nearest, best := 0, math.Inf(1)
+	within := []int{}
+	for i, e := range r.entries {
+		d := distance(pos, e.position)
+		if d <= r.radius {
+			within = append(within, i)
+		}
+		if d < best && !(e.lost && r.leg == "dl") {
+			nearest, best = i, d
+		}
+	}
+	n := int64(len(within))
+	for k := int64(0); k < n; k++ {
+		e := r.entries[within[(((seq+k)%n)+n)%n]]
+		if !e.lost || r.leg == "ul" {
+			return e
+		}
+	}
+	return r.entries[nearest]
+}
+
+// Matches the packet by its sequence number, or by the position of the
+// vehicle, and delays it like the recorded one.
+func (r *Replay) Delays(p *Packet, size int, now time.Time) []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var e replayEntry
+	if r.radius >= 0 && r.position != nil {
+		e = r.near(*r.position, p.Header.Seq)
+	} else {
+		n := int64(len(r.entries))
+		e = r.entries[((p.Header.Seq%n)+n)%n]
+	}
+	if e.lost && r.leg == "ul" {
+		return nil
+	}
+	delay := e.ul
+	if r.leg == "dl" {
+		delay = e.dl
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return []time.Duration{delay}
+}
+
+// Replace the impairments of the link with a replay of -replay, if set.
+func (l *Link) replay(leg string) {
+	if len(*replayFlag) == 0 {
+		return
+	}
+	if *replayByFlag != "seq" && *replayByFlag != "position" {
+		panic(fmt.Sprintf("Unsupported -replayBy \"%s\".", *replayByFlag))
+	}
+	r, err := NewReplay(*replayFlag, leg, *replayByFlag == "position")
+	if err != nil {
+		panic(err)
+	}
+	if *replayByFlag == "position" {
+		l.node.nc.Subscribe(fmt.Sprintf("%s.position", *replayFrom), r.setPosition)
+	}
+	l.impairment = r
+}
+
+// Publish where the vehicle is on "<name>.position" until it dies.
+func (n *Node) publishPosition(interval time.Duration, position func() Position) {
+	subject := fmt.Sprintf("%s.position", n.name)
+	for n.isAlive() {
+		pos := position()
+		pos.Stamp = time.Now().UnixNano()
+		n.nc.Publish(subject, pos)
+		time.Sleep(interval)
+	}
+}
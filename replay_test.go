@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// In position mode, both legs of a packet replay the same recorded packet,
+// and a lost one is lost once, on the uplink.
+func TestReplayByPosition(t *testing.T) {
+	log := []Packet{}
+	for seq := int64(0); seq < 10; seq++ {
+		if seq == 3 {
+			continue // lost
+		}
+		p := Packet{T2: seq * int64(time.Millisecond), T4: 2 * seq * int64(time.Millisecond)}
+		p.Header.Seq = seq
+		p.X = 1
+		log = append(log, p)
+	}
+	entries := replayEntries(log)
+	here := &Position{X: 2}
+	ul := &Replay{leg: "ul", entries: entries, radius: 10, position: here}
+	dl := &Replay{leg: "dl", entries: entries, radius: 10, position: here}
+
+	lost := 0
+	for seq := int64(0); seq < 1000; seq++ {
+		p := Packet{}
+		p.Header.Seq = seq
+		up := ul.Delays(&p, 0, time.Now())
+		if up == nil {
+			lost++
+			continue
+		}
+		down := dl.Delays(&p, 0, time.Now())
+		if down == nil || down[0] != 2*up[0] {
+			t.Fatalf("seq %d is delayed %v on the uplink and %v on the downlink", seq, up, down)
+		}
+	}
+	if lost != 100 {
+		t.Errorf("%d of 1000 lost, expected 100", lost)
+	}
+}
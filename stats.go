@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var statsInterval = flag.Duration("stats", 1*time.Second, "How often should vehicles publish statistics? (0 to disable)")
+var statsWindow = flag.Duration("statsWindow", 10*time.Second, "Over how long should vehicle statistics be computed?")
+
+// Value at quantile q (0-1) of sorted values, using linear interpolation like
+// pandas' quantile.
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// p50, p95 and p99 of values in ms, the values are sorted in place.
+func latencyPercentiles(values []float64) [3]float64 {
+	sort.Float64s(values)
+	return [3]float64{percentile(values, 0.5), percentile(values, 0.95), percentile(values, 0.99)}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type statsEntry struct {
+	arrival time.Time
+	packet  Packet
+}
+
+// Packets received within a sliding window, summarized by a vehicle.
+type RollingStats struct {
+	mu       sync.Mutex
+	window   time.Duration
+	entries  []statsEntry
+	received int
+}
+
+func NewRollingStats(window time.Duration) *RollingStats {
+	return &RollingStats{window: window}
+}
+
+func (s *RollingStats) add(p Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, statsEntry{arrival: time.Now(), packet: p})
+	s.received++
+}
+
+func (s *RollingStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.received = 0
+}
+
+func (s *RollingStats) summary(name string) VehicleStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for len(s.entries) > 0 && now.Sub(s.entries[0].arrival) > s.window {
+		s.entries = s.entries[1:]
+	}
+
+	stats := VehicleStats{Name: name, Stamp: now.UnixNano(), Window: s.window.Seconds(), Received: s.received}
+	if len(s.entries) == 0 {
+		return stats
+	}
+
+	packets := make([]Packet, 0, len(s.entries))
+	for _, e := range s.entries {
+		packets = append(packets, e.packet)
+	}
+	summarizePackets(&stats, packets)
+	return stats
+}
+
+// The packets of each sensor or RSU, in the order they first appear. Each
+// numbers its packets from 0, so sequence numbers only compare within one.
+func bySource(packets []Packet) [][]Packet {
+	index := map[string]int{}
+	groups := [][]Packet{}
+	for _, p := range packets {
+		i, ok := index[p.Source]
+		if !ok {
+			i = len(groups)
+			index[p.Source] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], p)
+	}
+	return groups
+}
+
+// Set the rate, loss and latency percentiles of the stats from packets in
+// any order. Loss and rate are over the packets of each source, the rate
+// being their sum.
+func summarizePackets(stats *VehicleStats, packets []Packet) {
+	if len(packets) == 0 {
+		return
+	}
+	ul := make([]float64, 0, len(packets))
+	dl := make([]float64, 0, len(packets))
+	e2e := make([]float64, 0, len(packets))
+	for _, p := range packets {
+		ul = append(ul, ms(p.Uplink()))
+		dl = append(dl, ms(p.Downlink()))
+		e2e = append(e2e, ms(p.EndToEnd()))
+	}
+
+	received, sent := 0, int64(0)
+	stats.Rate = 0
+	for _, group := range bySource(packets) {
+		seqs := map[int64]bool{}
+		minSeq, maxSeq := group[0].Header.Seq, group[0].Header.Seq
+		minT1, maxT1 := group[0].T1, group[0].T1
+		for _, p := range group {
+			seqs[p.Header.Seq] = true
+			if p.Header.Seq < minSeq {
+				minSeq = p.Header.Seq
+			}
+			if p.Header.Seq > maxSeq {
+				maxSeq = p.Header.Seq
+			}
+			if p.T1 < minT1 {
+				minT1 = p.T1
+			}
+			if p.T1 > maxT1 {
+				maxT1 = p.T1
+			}
+		}
+		received += len(seqs)
+		sent += maxSeq - minSeq + 1
+		if maxT1 > minT1 {
+			stats.Rate += float64(maxSeq-minSeq) / time.Duration(maxT1-minT1).Seconds()
+		}
+	}
+
+	stats.UL = latencyPercentiles(ul)
+	stats.DL = latencyPercentiles(dl)
+	stats.E2E = latencyPercentiles(e2e)
+	stats.Loss = 1 - float64(received)/float64(sent)
+	stats.Missed, stats.Late = deadlineMisses(packets)
+	stats.AoI, stats.PeakAoI = sourceAoI(packets)
+}
+
+func statsSubject(name string) string {
+	return fmt.Sprintf("%s.stats", name)
+}
+
+// Publish a summary of the received packets while the node is alive.
+func (n *Node) publishStats(interval time.Duration) {
+	for n.isAlive() {
+		time.Sleep(interval)
+		if n.isPaused() {
+			continue
+		}
+		stats := n.stats.summary(n.name)
+		if n.ntpClient != nil {
+			stats.NTP = n.ntpClient.GetOffset()
+		}
+		n.nc.Publish(statsSubject(n.name), &stats)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarizeSources(t *testing.T) {
+	packet := func(source string, seq int64) Packet {
+		p := Packet{Source: source, T1: seq * int64(100*time.Millisecond)}
+		p.Header.Seq = seq
+		p.T4 = p.T1 + int64(10*time.Millisecond)
+		p.Deadline = p.T1 + int64(50*time.Millisecond)
+		return p
+	}
+	// Two sensors at 10 Hz, both numbering from 0
+	log := []Packet{
+		packet("sensor1", 0), packet("sensor2", 0),
+		packet("sensor1", 1), packet("sensor2", 1),
+		packet("sensor1", 2), // sensor2's 2 is lost
+		packet("sensor1", 3), packet("sensor2", 3),
+	}
+	stats := VehicleStats{}
+	summarizePackets(&stats, log)
+	if !approx(stats.Loss, 1.0/8) || !approx(stats.Rate, 20) || !approx(stats.Missed, 1.0/8) {
+		t.Errorf("stats are %+v", stats)
+	}
+	// sensor1's age averages 60 ms, sensor2's (60*100 + 110*200) / 300 ms
+	if !approx(stats.AoI, (60+280.0/3)/2) || !approx(stats.PeakAoI, 210) {
+		t.Errorf("age of information %v, peak %v", stats.AoI, stats.PeakAoI)
+	}
+}
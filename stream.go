@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+var streamFlag = flag.String("stream", "", "Send frames of a video or lidar stream at the rate instead of single packets, DATA_SIZE being the mean frame size")
+var gopFlag = flag.String("gop", "IPPPPPPPPPPP", "Group of pictures of a video stream, of I and P frames")
+var iFrameRatio = flag.Float64("iRatio", 5, "How many times larger an I frame is than a P frame")
+var frameJitter = flag.Float64("frameJitter", 0.1, "Standard deviation of the frame sizes, relative to their mean")
+var chunkSize = flag.Int("chunk", 16000, "Largest fragment a frame is split into [B]")
+
+// Sizes of the frames of a video or LiDAR stream.
+type FrameSource struct {
+	kind   string
+	gop    string
+	ratio  float64
+	jitter float64
+	rng    *rand.Rand
+}
+
+func NewFrameSource(kind, gop string, ratio, jitter float64) (*FrameSource, error) {
+	switch kind {
+	case "video":
+		if len(gop) == 0 || strings.Trim(gop, "IP") != "" || gop[0] != 'I' {
+			return nil, fmt.Errorf("GOP \"%s\" is not an I frame followed by I and P frames", gop)
+		}
+	case "lidar":
+	default:
+		return nil, fmt.Errorf("unsupported stream \"%s\"", kind)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &FrameSource{kind: kind, gop: gop, ratio: ratio, jitter: jitter, rng: rng}, nil
+}
+
+// Size and type of frame number `frame`. Over a GOP, or many scans, the
+// frames average `mean` bytes.
+func (s *FrameSource) next(frame int64, mean int) (int, string) {
+	frameType, scale := "L", 1.0
+	if s.kind == "video" {
+		frameType = string(s.gop[frame%int64(len(s.gop))])
+		iFrames := float64(strings.Count(s.gop, "I"))
+		pFrame := float64(len(s.gop)) / (iFrames*s.ratio + float64(len(s.gop)) - iFrames)
+		if frameType == "I" {
+			scale = s.ratio * pFrame
+		} else {
+			scale = pFrame
+		}
+	}
+	size := float64(mean) * scale * (1 + s.jitter*s.rng.NormFloat64())
+	return int(math.Max(1, math.Round(size))), frameType
+}
+
+// Make the node send frames of -stream instead of single packets.
+func (n *Node) useStream() {
+	if len(*streamFlag) == 0 {
+		return
+	}
+	if n.payload != nil {
+		panic("Use either -stream or -payload, not both.")
+	}
+	if *chunkSize <= 0 {
+		panic("-chunk must be positive.")
+	}
+	source, err := NewFrameSource(*streamFlag, *gopFlag, *iFrameRatio, *frameJitter)
+	if err != nil {
+		panic(err)
+	}
+	n.stream = source
+	n.setAttr("FRAME_SEQ", 0)
+}
+
+// The packets to send next: the fragments of a frame if the node streams,
+// otherwise a single packet.
+func (n *Node) nextPackets() []Packet {
+	if n.stream == nil {
+		return []Packet{n.nextPacket()}
+	}
+	mean, _ := n.getAttr("DATA_SIZE")
+	seq, _ := n.getAttr("DATA_SEQ")
+	frame, _ := n.getAttr("FRAME_SEQ")
+	size, frameType := n.stream.next(int64(frame), mean)
+
+	// All fragments have the time the frame was captured as T1
+	stamp := time.Now().UnixNano()
+	offset := n.ntpClient.GetOffset()
+	fragments := (size + *chunkSize - 1) / *chunkSize
+	packets := make([]Packet, fragments)
+	for i := range packets {
+		data, err := RandomBytes(int(math.Min(float64(*chunkSize), float64(size-i**chunkSize))))
+		if err != nil {
+			panic(err)
+		}
+		p := &packets[i]
+		p.Header.Stamp = stamp
+		p.Header.Seq = int64(seq + i)
+		p.Source = n.name
+		p.Data = data
+		p.Size = len(data)
+		p.Chk = Checksum(data, 0)
+		p.T1, p.E1 = stamp, offset
+		n.stampDeadline(p)
+		p.FrameContext = FrameContext{Frame: int64(frame), FrameType: frameType, Fragment: i, Fragments: fragments}
+	}
+
+	n.setAttr("DATA_SEQ", seq+fragments)
+	n.setAttr("FRAME_SEQ", frame+1)
+	return packets
+}
+
+// How a vehicle received the frames of a stream. A frame is lost if any of
+// its fragments is, and its latency is from when it was captured until its
+// last fragment arrived.
+type StreamResult struct {
+	Frames     int        `yaml:"frames"`   // sent, by FRAME_SEQ of each sender
+	Received   int        `yaml:"received"` // with all fragments
+	Loss       float64    `yaml:"loss"`
+	Latency    [3]float64 `yaml:"latency,flow"` // p50, p95, p99 [ms]
+	MaxLatency float64    `yaml:"max_latency"`  // [ms]
+}
+
+// Frame level delivery of the streams in a log, nil if it has none. `sent`
+// has how many frames each source sent, its FRAME_SEQ; for sources without,
+// the frames from the first to the last number received count.
+func frameStats(log []Packet, sent map[string]int) *StreamResult {
+	type frameArrival struct {
+		fragments map[int]bool
+		total     int
+		captured  int64 // NTP corrected [ns]
+		last      int64
+	}
+	result := &StreamResult{}
+	latencies := []float64{}
+	for _, group := range bySource(log) {
+		frames := map[int64]*frameArrival{}
+		minFrame, maxFrame := int64(math.MaxInt64), int64(math.MinInt64)
+		for _, p := range group {
+			if p.Fragments == 0 {
+				continue
+			}
+			f, ok := frames[p.Frame]
+			if !ok {
+				f = &frameArrival{fragments: map[int]bool{}, total: p.Fragments, captured: p.T1 + p.E1}
+				frames[p.Frame] = f
+			}
+			f.fragments[p.Fragment] = true
+			if arrival := p.T4 + p.E4; arrival > f.last {
+				f.last = arrival
+			}
+			if p.Frame < minFrame {
+				minFrame = p.Frame
+			}
+			if p.Frame > maxFrame {
+				maxFrame = p.Frame
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		if n := sent[group[0].Source]; n > 0 {
+			result.Frames += n
+		} else {
+			result.Frames += int(maxFrame - minFrame + 1)
+		}
+		for _, f := range frames {
+			if len(f.fragments) == f.total {
+				latencies = append(latencies, ms(time.Duration(f.last-f.captured)))
+			}
+		}
+	}
+	if result.Frames == 0 {
+		return nil
+	}
+
+	result.Received = len(latencies)
+	result.Loss = 1 - float64(result.Received)/float64(result.Frames)
+	if len(latencies) != 0 {
+		result.Latency = latencyPercentiles(latencies)
+		result.MaxLatency = latencies[len(latencies)-1]
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFrameSource(t *testing.T) {
+	video, err := NewFrameSource("video", "IPPP", 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// P frames are 4/8 of the mean, I frames five times that
+	sizes := []int{}
+	types := ""
+	for frame := int64(0); frame < 5; frame++ {
+		size, frameType := video.next(frame, 8000)
+		sizes = append(sizes, size)
+		types += frameType
+	}
+	if types != "IPPPI" || sizes[0] != 20000 || sizes[1] != 4000 || sizes[4] != 20000 {
+		t.Errorf("frames %s of %v", types, sizes)
+	}
+
+	lidar, _ := NewFrameSource("lidar", "", 0, 0.1)
+	sum := 0
+	for frame := int64(0); frame < 1000; frame++ {
+		size, frameType := lidar.next(frame, 300000)
+		if frameType != "L" {
+			t.Fatalf("scan is a %s frame", frameType)
+		}
+		sum += size
+	}
+	if mean := float64(sum) / 1000; mean < 295000 || mean > 305000 {
+		t.Errorf("scans average %v B", mean)
+	}
+
+	for _, bad := range []string{"", "PPI", "IBBP"} {
+		if _, err := NewFrameSource("video", bad, 5, 0); err == nil {
+			t.Errorf("GOP %q accepted", bad)
+		}
+	}
+	if _, err := NewFrameSource("audio", "", 0, 0); err == nil {
+		t.Error("unknown stream accepted")
+	}
+}
+
+func TestNextFrame(t *testing.T) {
+	node := &Node{name: "sensor", attr: map[string]int{"DATA_SIZE": 50000, "DATA_SEQ": 7}, ntpClient: &NTPClient{}}
+	node.stream, _ = NewFrameSource("lidar", "", 0, 0)
+	node.setAttr("FRAME_SEQ", 3)
+	setFlags(t, map[string]string{"chunk": "16000"})
+
+	packets := node.nextPackets()
+	if len(packets) != 4 {
+		t.Fatalf("50000 B frame in %d fragments", len(packets))
+	}
+	total := 0
+	for i, p := range packets {
+		total += len(p.Data)
+		if p.Header.Seq != int64(7+i) || p.Frame != 3 || p.Fragment != i || p.Fragments != 4 || p.T1 != packets[0].T1 {
+			t.Errorf("fragment %d is %+v", i, p.FrameContext)
+		}
+	}
+	if total != 50000 || len(packets[3].Data) != 2000 {
+		t.Errorf("fragments have %d B, the last %d B", total, len(packets[3].Data))
+	}
+	if seq, _ := node.getAttr("DATA_SEQ"); seq != 11 {
+		t.Errorf("DATA_SEQ is %d", seq)
+	}
+	if frame, _ := node.getAttr("FRAME_SEQ"); frame != 4 {
+		t.Errorf("FRAME_SEQ is %d", frame)
+	}
+}
+
+func TestFrameStats(t *testing.T) {
+	fragment := func(frame int64, i, n int, latency int64) Packet {
+		p := Packet{T1: frame * 100e6, T4: frame*100e6 + latency*1e6}
+		p.FrameContext = FrameContext{Frame: frame, Fragment: i, Fragments: n}
+		return p
+	}
+	log := []Packet{
+		fragment(0, 0, 2, 10), fragment(0, 1, 2, 30),
+		fragment(1, 1, 2, 20), // fragment 0 lost
+		fragment(2, 0, 1, 15),
+		// frame 3 lost entirely
+		fragment(4, 1, 2, 50), fragment(4, 0, 2, 40), fragment(4, 0, 2, 45), // duplicate
+	}
+	s := frameStats(log, nil)
+	if s == nil || s.Frames != 5 || s.Received != 3 || !approx(s.Loss, 0.4) {
+		t.Fatalf("stats are %+v", s)
+	}
+	if !approx(s.Latency[0], 30) || !approx(s.MaxLatency, 50) {
+		t.Errorf("latency %v, max %v", s.Latency, s.MaxLatency)
+	}
+
+	// The sender's FRAME_SEQ counts the frames lost at the end, and a
+	// second one's frames with the same numbers are its own
+	lidar := fragment(0, 0, 1, 20)
+	lidar.Source = "lidar"
+	if s := frameStats(append(log, lidar), map[string]int{"": 8, "lidar": 2}); s.Frames != 10 || s.Received != 4 {
+		t.Errorf("stats with the frames sent are %+v", s)
+	}
+	if frameStats([]Packet{{}}, nil) != nil {
+		t.Error("stats of a log without a stream")
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"time"
+
 	"github.com/bluenviron/goroslib/v2/pkg/msg"
 	"github.com/bluenviron/goroslib/v2/pkg/msgs/std_msgs"
 )
@@ -30,6 +34,21 @@ type SetResponse struct {
 	Reason  string `json:"reason"`
 }
 
+type SetLogRequest struct {
+	Author string   `json:"author"`
+	Data   []Packet `json:"data"`
+}
+
+// Nodes from before the audit log send the packets alone, without an author.
+func (r *SetLogRequest) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) != 0 && data[0] == '[' {
+		r.Author = ""
+		return json.Unmarshal(data, &r.Data)
+	}
+	type request SetLogRequest // without this method
+	return json.Unmarshal(data, (*request)(r))
+}
+
 type SetMsg struct {
 	Request  SetRequest  `json:"request"`
 	Response SetResponse `json:"response"`
@@ -62,6 +81,7 @@ type Packet struct {
 	E2        int64   `json:"e2"`
 	E3        int64   `json:"e3"`
 	E4        int64   `json:"e4"`
+	Deadline  int64   `json:"deadline"` // NTP corrected time it must arrive by [ns], 0 if none
 	X         float64 `json:"x"`
 	Y         float64 `json:"y"`
 	Yaw       float64 `json:"yaw"`
@@ -70,4 +90,113 @@ type Packet struct {
 	Longitude float64 `json:"longitude"`
 	Data      []byte  `json:"data"`
 	Chk       int     `json:"chk"`
+	Payload   string  `json:"payload,omitempty"` // kind and encoding of Data, e.g. cam/uper, empty if random
+	Size      int     `json:"size"`              // of Data, set by the vehicle before it is emptied
+	Source    string  `json:"source,omitempty"`  // name of the sensor or RSU that sent it
+	RadioContext
+	GNSSContext
+	FrameContext
+}
+
+// Latency from sensor to server, corrected with the NTP offsets.
+func (p *Packet) Uplink() time.Duration {
+	return time.Duration((p.T2 + p.E2) - (p.T1 + p.E1))
+}
+
+// Latency from server to vehicle, corrected with the NTP offsets.
+func (p *Packet) Downlink() time.Duration {
+	return time.Duration((p.T4 + p.E4) - (p.T3 + p.E3))
+}
+
+// Latency from sensor to vehicle, corrected with the NTP offsets.
+func (p *Packet) EndToEnd() time.Duration {
+	return time.Duration((p.T4 + p.E4) - (p.T1 + p.E1))
+}
+
+type NTPStatus struct {
+	Server  string `json:"server"`
+	Offset  int64  `json:"offset"`
+	RTT     int64  `json:"rtt"`
+	Stratum uint8  `json:"stratum"`
+	Valid   bool   `json:"valid"`
+}
+
+type Heartbeat struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	Host    string         `json:"host"`
+	Stamp   int64          `json:"stamp"`
+	NTP     NTPStatus      `json:"ntp"`
+	Params  map[string]int `json:"params"`
+}
+
+type DiscoverRequest struct {
+	Author string `json:"author"`
+}
+
+// Summary of the packets a vehicle received recently, streamed while a test
+// is running.
+type VehicleStats struct {
+	Name     string     `json:"name"`
+	Stamp    int64      `json:"stamp"`
+	Window   float64    `json:"window"`   // [s]
+	Received int        `json:"received"` // since the log was last cleared
+	Rate     float64    `json:"rate"`     // [Hz] at which the sensor sent them
+	Loss     float64    `json:"loss"`     // fraction of sequence numbers missing
+	UL       [3]float64 `json:"ul"`       // p50, p95, p99 [ms]
+	DL       [3]float64 `json:"dl"`       // p50, p95, p99 [ms]
+	E2E      [3]float64 `json:"e2e"`      // p50, p95, p99 [ms]
+	Missed   float64    `json:"missed"`   // fraction of packets not received by their deadline
+	Late     float64    `json:"late"`     // fraction of the received packets after their deadline
+	AoI      float64    `json:"aoi"`      // time average age of information [ms]
+	PeakAoI  float64    `json:"peak_aoi"` // [ms]
+	NTP      int64      `json:"ntp"`      // [ns]
+}
+
+// Where a vehicle is, streamed so other nodes can replay location dependent
+// network conditions.
+type Position struct {
+	Stamp     int64   `json:"stamp"`
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Serving cell of the vehicle's modem when a packet arrived.
+type RadioContext struct {
+	CellID int64   `json:"cell_id" yaml:"cell_id"`
+	PCI    int     `json:"pci" yaml:"pci"`
+	RSRP   float64 `json:"rsrp" yaml:"rsrp"` // [dBm]
+	RSRQ   float64 `json:"rsrq" yaml:"rsrq"` // [dB]
+	SINR   float64 `json:"sinr" yaml:"sinr"` // [dB]
+	Band   int     `json:"band" yaml:"band"`
+}
+
+// Quality of the vehicle's GNSS fix when a packet arrived.
+type GNSSContext struct {
+	Fix        int     `json:"fix"`         // GGA fix quality, 0 is none
+	HDOP       float64 `json:"hdop"`        // 0 if unknown
+	GNSSOffset int64   `json:"gnss_offset"` // of the vehicle clock to GNSS time [ns], 0 if unknown
+}
+
+// Which frame of a stream a packet is a fragment of, see -stream.
+type FrameContext struct {
+	Frame     int64  `json:"frame"`
+	FrameType string `json:"frame_type,omitempty"` // I or P for video, L for a LiDAR scan
+	Fragment  int    `json:"fragment"`
+	Fragments int    `json:"fragments"` // of the frame, 0 if not a stream
+}
+
+// A change of serving cell, detected by the vehicle.
+type Handover struct {
+	Vehicle   string       `json:"vehicle,omitempty" yaml:"vehicle,omitempty"`
+	Stamp     int64        `json:"stamp" yaml:"stamp"` // NTP corrected [ns]
+	From      RadioContext `json:"from" yaml:"from"`
+	To        RadioContext `json:"to" yaml:"to"`
+	X         float64      `json:"x" yaml:"x"`
+	Y         float64      `json:"y" yaml:"y"`
+	Latitude  float64      `json:"latitude" yaml:"latitude"`
+	Longitude float64      `json:"longitude" yaml:"longitude"`
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/csv"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -53,3 +56,43 @@ func loadConf(file string) Config {
 	}
 	return conf
 }
+
+// Write `v` as YAML after an optional header. The file is replaced
+// atomically, so an interrupted write never leaves a broken file behind.
+func writeYAML(file string, header string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, append([]byte(header), data...), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Write a CSV file of a header and rows.
+func writeCSV(file string, header []string, rows [][]string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(header)
+	if err := w.WriteAll(rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Comma-separated names, without blanks.
+func splitNames(s string) []string {
+	names := []string{}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}